@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// Deliver attributes a message received by a client to the request
+// of the client which most likely produced it.
+// Envelopes do not carry the request ID, so a request is chosen by
+// the last envelope hash reported in its completion signal, and otherwise
+// by the topic and the time window: the oldest pending request first,
+// then the one which finished most recently within the tracker timeout.
+// Envelopes received from other peers can't be told apart, so new envelopes
+// of the channel may be attributed to a pending request as well.
+func (t *requestTracker) Deliver(client int, msg *whisper.Message, receivedAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hash := hex.EncodeToString(msg.Hash)
+	key := fmt.Sprintf("%d/%s", client, hash)
+	t.delivered[key]++
+	duplicate := t.delivered[key] > 1
+	if duplicate {
+		duplicateEnvelopesCounter.Inc()
+	}
+
+	r := t.attribute(client, hash, msg, receivedAt)
+	if r == nil {
+		t.orphans++
+		return
+	}
+
+	t.topics[msg.Topic]++
+	r.Envelopes++
+	r.Bytes += messageSize(msg)
+	if duplicate {
+		r.Duplicates++
+	}
+	if r.Outcome != "" {
+		r.Late++
+		lateEnvelopesCounter.Inc()
+	}
+}
+
+// attribute must be called with the lock held.
+func (t *requestTracker) attribute(client int, hash string, msg *whisper.Message, receivedAt time.Time) *trackedRequest {
+	var recent []*trackedRequest
+	for i := len(t.finished) - 1; i >= 0; i-- {
+		r := t.finished[i]
+		if receivedAt.Sub(r.FinishedAt) > t.timeout {
+			break
+		}
+		if r.Client == client && r.LastEnvelopeHash == hash {
+			return r
+		}
+		recent = append(recent, r)
+	}
+
+	var oldest *trackedRequest
+	for _, r := range t.pending {
+		if r.matches(client, msg, receivedAt) && (oldest == nil || r.SentAt.Before(oldest.SentAt)) {
+			oldest = r
+		}
+	}
+	if oldest != nil {
+		return oldest
+	}
+
+	for _, r := range recent {
+		if r.matches(client, msg, receivedAt) {
+			return r
+		}
+	}
+	return nil
+}
+
+// matches returns true if the request could have produced the message
+// received by the client.
+func (r *trackedRequest) matches(client int, msg *whisper.Message, receivedAt time.Time) bool {
+	if r.Hash == "" || r.Client != client || receivedAt.Before(r.SentAt) {
+		return false
+	}
+	sent := time.Unix(int64(msg.Timestamp), 0)
+	if sent.Before(r.From.Truncate(time.Second)) || sent.After(r.To) {
+		return false
+	}
+	for _, topic := range r.Topics {
+		if topic == msg.Topic {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverySummary describes envelopes delivered for all requests.
+type deliverySummary struct {
+	Envelopes  int `json:"envelopes"`
+	Bytes      int `json:"bytes"`
+	Late       int `json:"late"`
+	Duplicates int `json:"duplicates"`
+	Orphans    int `json:"orphans"` // not attributed to any request
+	// Envelopes of a single request.
+	MinEnvelopes int `json:"minEnvelopes"`
+	MaxEnvelopes int `json:"maxEnvelopes"`
+	// Envelopes by the channel of their topic, or the topic if it is unknown.
+	Topics map[string]int `json:"topics"`
+}
+
+// Deliveries summarizes envelopes delivered for the requests.
+// Requested topics without any envelopes are included.
+func (t *requestTracker) Deliveries(requests []*trackedRequest, names map[whisper.TopicType]string) deliverySummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := deliverySummary{Orphans: t.orphans, Topics: make(map[string]int)}
+	for _, name := range names {
+		s.Topics[name] = 0
+	}
+	for topic, n := range t.topics {
+		name, ok := names[topic]
+		if !ok {
+			name = topic.String()
+		}
+		s.Topics[name] += n
+	}
+
+	for i, r := range requests {
+		s.Envelopes += r.Envelopes
+		s.Bytes += r.Bytes
+		s.Late += r.Late
+		s.Duplicates += r.Duplicates
+		if i == 0 || r.Envelopes < s.MinEnvelopes {
+			s.MinEnvelopes = r.Envelopes
+		}
+		if r.Envelopes > s.MaxEnvelopes {
+			s.MaxEnvelopes = r.Envelopes
+		}
+	}
+	return s
+}
+
+func (s deliverySummary) String() string {
+	return fmt.Sprintf("envelopes=%d bytes=%d per request min=%d max=%d late=%d duplicates=%d orphans=%d",
+		s.Envelopes, s.Bytes, s.MinEnvelopes, s.MaxEnvelopes, s.Late, s.Duplicates, s.Orphans)
+}
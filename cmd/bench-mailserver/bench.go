@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/status-im/statusd-bots/mailserver"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// workload describes requests of a single run.
+type workload struct {
+	clients     []*benchClient // each runs the load profile
+	mailServers []string       // each request is sent to all of them
+	load        loadProfile    // ignored when syncing
+	sync        bool           // only with a single client and mail server
+	topics      []whisper.TopicType
+	window      time.Duration // length of the requested time span
+	limit       int
+}
+
+// benchmark sends requests of clients to mail servers and
+// routes signals and messages to the current run.
+type benchmark struct {
+	clients     []*benchClient
+	names       map[whisper.TopicType]string // channels by topic
+	connections map[string]*connectSummary   // by mail server
+	delivered   deliveryCounter
+
+	mu      sync.Mutex
+	tracker *requestTracker
+}
+
+func (b *benchmark) current() *requestTracker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tracker
+}
+
+// HandleResponse passes a mail server response to the current run.
+func (b *benchmark) HandleResponse(r mailserver.Response) {
+	if t := b.current(); t != nil {
+		t.HandleResponse(r)
+	}
+}
+
+// Deliver passes a message received by a client to the current run.
+func (b *benchmark) Deliver(client int, msg *whisper.Message) {
+	receivedBytesCounter.Add(float64(b.delivered.Add(msg)))
+	if t := b.current(); t != nil {
+		t.Deliver(client, msg, time.Now())
+	}
+}
+
+// Run sends requests of the workload from each of its clients to each of its
+// mail servers and returns when all of them finish. Results are returned
+// per mail server.
+func (b *benchmark) Run(w workload, config benchConfig) []*benchResult {
+	tracker := newRequestTracker(*timeout)
+	b.mu.Lock()
+	b.tracker = tracker
+	b.mu.Unlock()
+	go tracker.Run()
+
+	bits, falsePositives := bloomStats(w.topics)
+	log.Printf("requesting %d topics, bloom filter has %d bits set with a false positive rate of %.4f%%",
+		len(w.topics), bits, falsePositives*100)
+
+	started := time.Now()
+	var synced *syncResult
+	if w.sync {
+		// All pages share the time span of the first one.
+		to := time.Now()
+		result := syncHistory(func(cursor string) *trackedRequest {
+			tracker.Expect(1)
+			return b.send(tracker, w, w.clients[0], w.mailServers[0], to, cursor)
+		}, &b.delivered)
+		synced = &result
+	} else {
+		var wg sync.WaitGroup
+		for _, client := range w.clients {
+			wg.Add(1)
+			go func(client *benchClient) {
+				defer wg.Done()
+				w.load.Run(func() {
+					now := time.Now()
+					for _, server := range w.mailServers {
+						tracker.Expect(1)
+						go b.send(tracker, w, client, server, now, "")
+					}
+				})
+			}(client)
+		}
+		wg.Wait()
+	}
+	tracker.SentAll()
+
+	<-tracker.Done()
+	if !w.sync {
+		// Syncing waits for the last envelopes already.
+		time.Sleep(deliveryGrace)
+	}
+
+	var results []*benchResult
+	finished := tracker.Finished()
+	for _, server := range w.mailServers {
+		var requests []*trackedRequest
+		for _, r := range finished {
+			if r.MailServer == server {
+				requests = append(requests, r)
+			}
+		}
+
+		c := config
+		c.MailServer = server
+		if len(w.clients) > 1 {
+			c.Clients = len(w.clients)
+		}
+		result := newBenchResult(c, started, requests, tracker, b.names)
+		result.Sync = synced
+		result.Connections = b.connections[server]
+		if len(w.mailServers) > 1 {
+			// Envelopes can't be told apart by the mail server which sent them.
+			result.Deliveries = nil
+			for i := range result.Clients {
+				result.Clients[i].Envelopes = 0
+			}
+		}
+		logBenchResult(result, requests)
+		results = append(results, result)
+	}
+	if len(w.mailServers) > 1 {
+		deliveries := tracker.Deliveries(finished, b.names)
+		log.Printf("deliveries from all mail servers: %s", deliveries)
+		log.Printf("envelopes per topic: %s", formatTopicCounts(deliveries.Topics))
+	}
+	durationGauge.Set(time.Since(started).Seconds())
+
+	return results
+}
+
+func logBenchResult(result *benchResult, requests []*trackedRequest) {
+	log.Printf("results of %s", result.Config.MailServer)
+	log.Printf("result: %v unmatched signals: %d", result.Outcomes, result.UnmatchedSignals)
+	log.Printf("latency: %s", result.Latency)
+	log.Printf("latency histogram:\n%s", formatLatencyHistogram(completedLatencies(requests)))
+	if result.Deliveries != nil {
+		log.Printf("deliveries: %s", result.Deliveries)
+		log.Printf("envelopes per topic: %s", formatTopicCounts(result.Deliveries.Topics))
+	}
+	if result.Sync != nil {
+		log.Printf("sync: %s", result.Sync)
+		syncDurationGauge.Set(result.Sync.Duration.Seconds())
+	}
+	if result.Slices != nil {
+		log.Printf("results per %s slice:\n%s", result.Config.Slice, formatSliceResults(result.Slices, result.Config.Slice))
+	}
+	if result.Clients != nil {
+		log.Printf("results per client:\n%s", formatClientResults(result.Clients, result.Deliveries != nil))
+	}
+}
+
+// send requests envelopes of the workload topics sent within the window before to.
+func (b *benchmark) send(tracker *requestTracker, w workload, client *benchClient, mailServer string, to time.Time, cursor string) *trackedRequest {
+	params := requestParams{Client: client.ID, MailServer: mailServer, Topics: w.topics, From: to.Add(-w.window), To: to}
+	sentAt := time.Now()
+	hash, err := mailserver.Send(client.api, mailserver.Request{
+		MailServer: mailServer,
+		SymKeyID:   client.symKeyID,
+		From:       params.From,
+		To:         params.To,
+		Limit:      w.limit,
+		Cursor:     cursor,
+		Topics:     w.topics,
+	})
+	if err != nil {
+		log.Printf("failed to request for messages: %v", err)
+		return tracker.SendFailed(sentAt, params, err)
+	}
+	log.Printf("requested for messages with a request hash: %s", hash)
+	return tracker.Sent(hash, sentAt, params)
+}
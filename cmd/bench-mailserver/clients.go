@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/status-im/status-go/node"
+	"github.com/status-im/status-go/params"
+	"github.com/status-im/status-go/services/shhext"
+	"github.com/status-im/statusd-bots/protocol"
+	"github.com/status-im/whisper/shhclient"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// benchClient is a node with its own identity and peer connections
+// which requests history from Mail Servers.
+type benchClient struct {
+	ID       int
+	config   *params.NodeConfig
+	node     *node.StatusNode
+	shh      *shhclient.Client
+	api      *shhext.PublicAPI
+	symKeyID string // of the Mail Server password
+}
+
+// clientMessage is a message received by a client.
+type clientMessage struct {
+	client int
+	*whisper.Message
+}
+
+// checkClients returns an error if options can't be used with --clients.
+func checkClients() error {
+	if *clientCount < 1 {
+		return errors.New("--clients must be positive")
+	}
+	if *clientCount > 1 && *syncMode {
+		return errors.New("--sync can't be used with several clients")
+	}
+	return nil
+}
+
+// startBenchClient starts a node of a client. If there are several clients,
+// each node has a generated key and its own data directory, and all but
+// the first one listen on a random port.
+func startBenchClient(id, total int) (*benchClient, error) {
+	// All directories of the node are derived from its data directory.
+	dataDir := *datadir
+	if total > 1 && dataDir != "" {
+		dataDir = filepath.Join(dataDir, fmt.Sprintf("client-%d", id))
+	}
+	config, err := newNodeConfig(dataDir, *address, *fleet, params.MainNetworkID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a config: %v", err)
+	}
+	if total > 1 {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate a node key: %v", err)
+		}
+		config.NodeKey = hex.EncodeToString(crypto.FromECDSA(key))
+
+		if id > 0 {
+			host, _, err := net.SplitHostPort(config.ListenAddr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid listen address: %v", err)
+			}
+			config.ListenAddr = net.JoinHostPort(host, "0")
+		}
+	}
+	log.Printf("using config for client %d: %v", id, config)
+
+	n := node.New()
+	if err := n.Start(config); err != nil {
+		return nil, fmt.Errorf("failed to start a node: %v", err)
+	}
+	// Message events show when the Whisper handshake completes. status-go
+	// has no option for them, so they are enabled before peers are added.
+	n.Server().EnableMsgEvents = true
+
+	rpcClient, err := n.GethNode().Attach()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an rpc: %v", err)
+	}
+	shh := shhclient.NewClient(rpcClient)
+
+	shhextService, err := n.ShhExtService()
+	if err != nil {
+		return nil, fmt.Errorf("failed go get an shhext service: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	symKeyID, err := shh.GenerateSymmetricKeyFromPassword(ctx, protocol.MailServerPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate sym key for mail server: %v", err)
+	}
+
+	return &benchClient{
+		ID:       id,
+		config:   config,
+		node:     n,
+		shh:      shh,
+		api:      shhext.NewPublicAPI(shhextService),
+		symKeyID: symKeyID,
+	}, nil
+}
+
+// Subscribe forwards messages of the channels received by the client
+// and errors of the subscriptions.
+func (c *benchClient) Subscribe(channels []string, out chan<- clientMessage, errs chan<- error) error {
+	messages := make(chan *whisper.Message)
+	for _, chat := range channels {
+		symKeyID, err := addPublicChatSymKey(c.shh, chat)
+		if err != nil {
+			return fmt.Errorf("failed to add sym key for channel '%s': %v", chat, err)
+		}
+
+		sub, err := subscribeMessages(c.shh, chat, symKeyID, messages)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to messages for channel '%s': %v", chat, err)
+		}
+		go func() { errs <- <-sub.Err() }()
+	}
+
+	go func() {
+		for msg := range messages {
+			out <- clientMessage{client: c.ID, Message: msg}
+		}
+	}()
+	return nil
+}
+
+// clientResult holds results of requests sent by a single client.
+type clientResult struct {
+	Client    int            `json:"client"`
+	Requests  int            `json:"requests"`
+	Outcomes  map[string]int `json:"outcomes"`
+	ErrorRate float64        `json:"errorRate"`
+	Latency   latencySummary `json:"latency"`
+	Envelopes int            `json:"envelopes,omitempty"` // unless run in parallel
+}
+
+// clientResults groups requests by the client which sent them.
+func clientResults(requests []*trackedRequest, clients int) []clientResult {
+	byClient := make([][]*trackedRequest, clients)
+	for _, r := range requests {
+		byClient[r.Client] = append(byClient[r.Client], r)
+	}
+
+	results := make([]clientResult, 0, clients)
+	for id, selected := range byClient {
+		result := clientResult{
+			Client:   id,
+			Requests: len(selected),
+			Outcomes: countOutcomes(selected),
+			Latency:  summarizeLatencies(completedLatencies(selected)),
+		}
+		result.ErrorRate = errorRate(result.Requests, result.Outcomes)
+		for _, r := range selected {
+			result.Envelopes += r.Envelopes
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// formatClientResults draws a table with a row per client.
+// Envelopes are left out if they can't be attributed to the mail server.
+func formatClientResults(results []clientResult, envelopes bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %6s %8s %8s %9s %9s %9s %9s\n",
+		"client", "requests", "errors", "p50", "p90", "p99", "envelopes")
+
+	for _, r := range results {
+		n := "-"
+		if envelopes {
+			n = strconv.Itoa(r.Envelopes)
+		}
+		fmt.Fprintf(&b, "  %6d %8d %7.2f%% %9s %9s %9s %9s\n",
+			r.Client, r.Requests, r.ErrorRate*100,
+			round(r.Latency.P50), round(r.Latency.P90), round(r.Latency.P99), n)
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// selectMailServers returns Mail Servers given with the flags, all Mail Servers
+// of the fleet, or a random one from the fleet.
+func selectMailServers(fleetServers []string) ([]string, error) {
+	if len(*mailservers) > 0 && *allServers {
+		return nil, errors.New("--mailserver can't be used with --all-mailservers")
+	}
+	if len(*mailservers) > 0 {
+		return *mailservers, nil
+	}
+	if len(fleetServers) == 0 {
+		return nil, fmt.Errorf("no Mail Servers in fleet %s", *fleet)
+	}
+	if *allServers {
+		return fleetServers, nil
+	}
+	return []string{fleetServers[rand.Intn(len(fleetServers))]}, nil
+}
+
+// checkComparison returns an error if options can't be used
+// when several Mail Servers are benchmarked.
+func checkComparison() error {
+	if len(*mailservers) <= 1 && !*allServers {
+		return nil
+	}
+	if *baseline != "" {
+		return errors.New("--baseline can't be used with several Mail Servers")
+	}
+	if *syncMode && *parallel {
+		return errors.New("--sync can't be used with --parallel")
+	}
+	return nil
+}
+
+// formatComparison draws a table with a row per Mail Server.
+// Throughput is the number of completed requests and envelopes per second of the run.
+// Envelopes are not known per mail server if they were run in parallel.
+func formatComparison(results []*benchResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %-22s %8s %8s %9s %9s %9s %9s %10s\n",
+		"mailserver", "requests", "errors", "p50", "p90", "p99", "req/s", "envelope/s")
+
+	for _, r := range results {
+		seconds := r.Duration.Seconds()
+		if seconds == 0 {
+			seconds = 1
+		}
+		envelopes := "-"
+		if r.Deliveries != nil {
+			envelopes = fmt.Sprintf("%.2f", float64(r.Deliveries.Envelopes)/seconds)
+		}
+		fmt.Fprintf(&b, "  %-22s %8d %7.2f%% %9s %9s %9s %9.2f %10s\n",
+			mailServerLabel(r.Config.MailServer), r.Requests, r.ErrorRate*100,
+			round(r.Latency.P50), round(r.Latency.P90), round(r.Latency.P99),
+			float64(r.Outcomes[outcomeCompleted])/seconds, envelopes)
+	}
+	return b.String()
+}
@@ -4,9 +4,9 @@ import (
 	"github.com/status-im/status-go/params"
 )
 
-func newNodeConfig(address, fleet string, networkID uint64) (*params.NodeConfig, error) {
+func newNodeConfig(dataDir, address, fleet string, networkID uint64) (*params.NodeConfig, error) {
 	c, err := params.NewNodeConfigWithDefaults(
-		*datadir, networkID, params.WithFleet(fleet))
+		dataDir, networkID, params.WithFleet(fleet))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// whisperStatusCode is the code of the message which both peers send
+// to complete the Whisper handshake.
+const whisperStatusCode = 0
+
+// connectSpacing is the minimum time between connections to a Mail Server.
+// A node does not redial a peer for 35 seconds after the last dial, and
+// a Mail Server rejects connections from an Internet address which connected
+// within the last 30 seconds, which is the same for all clients.
+const connectSpacing = 36 * time.Second
+
+// connectPacer spaces out connections of all clients to each Mail Server.
+type connectPacer struct {
+	mu   sync.Mutex
+	last map[string]time.Time // by Mail Server
+}
+
+func newConnectPacer() *connectPacer {
+	return &connectPacer{last: make(map[string]time.Time)}
+}
+
+// Wait blocks until a connection to the Mail Server can be made.
+func (p *connectPacer) Wait(mailServer string) {
+	p.mu.Lock()
+	at := time.Now()
+	if last, ok := p.last[mailServer]; ok && last.Add(connectSpacing).After(at) {
+		at = last.Add(connectSpacing)
+	}
+	p.last[mailServer] = at
+	p.mu.Unlock()
+
+	if wait := time.Until(at); wait > 0 {
+		log.Printf("waiting %s to connect to Mail Server %s", round(wait), mailServer)
+		time.Sleep(wait)
+	}
+}
+
+// connectAttempt is a connection to a Mail Server. Phases are measured
+// from adding the Mail Server as a peer.
+type connectAttempt struct {
+	// The peer is added after the TCP connection and the encryption and
+	// devp2p handshakes; there is no event for the TCP connection alone.
+	Dial time.Duration
+	// The Whisper status message of the Mail Server is received,
+	// which completes the protocol handshake.
+	Handshake time.Duration
+	Err       error
+}
+
+// Connect connects to a Mail Server and reconnects the given number of times
+// to measure connection setup. Failed attempts are recorded, but an error is
+// returned only if the Mail Server doesn't become a peer in the end, as it
+// has to be one for the benchmark.
+func (c *benchClient) Connect(mailServer string, reconnects int, pacer *connectPacer) ([]connectAttempt, error) {
+	n, err := enode.ParseV4(mailServer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Mail Server enode: %v", err)
+	}
+
+	var attempts []connectAttempt
+	for i := 0; i <= reconnects; i++ {
+		if i > 0 {
+			if err := c.disconnect(n); err != nil {
+				log.Printf("failed to disconnect from '%s', no more reconnects: %v", mailServer, err)
+				break
+			}
+		}
+		pacer.Wait(mailServer)
+
+		log.Printf("adding Mail Server %s as a peer of client %d", mailServer, c.ID)
+		a := c.connect(n, mailServer)
+		if a.Err != nil {
+			log.Printf("failed to connect to '%s': %v", mailServer, a.Err)
+		} else {
+			label := mailServerLabel(mailServer)
+			connectDurationHistogram.WithLabelValues(label, "dial").Observe(a.Dial.Seconds())
+			connectDurationHistogram.WithLabelValues(label, "handshake").Observe(a.Handshake.Seconds())
+		}
+		attempts = append(attempts, a)
+	}
+
+	if !c.connected(n) {
+		// The Mail Server is still a static peer, so it is redialed.
+		log.Printf("waiting for Mail Server %s to be redialed", mailServer)
+		if err := c.waitConnected(n, mailServer, connectSpacing+*connTimeout); err != nil {
+			return attempts, fmt.Errorf("failed to connect to '%s': %v", mailServer, err)
+		}
+	}
+	return attempts, nil
+}
+
+func (c *benchClient) connect(n *enode.Node, url string) connectAttempt {
+	var a connectAttempt
+
+	// The channel is buffered because the server waits for
+	// subscribers to receive every event.
+	events := make(chan *p2p.PeerEvent, 16)
+	sub := c.node.Server().SubscribeEvents(events)
+	defer sub.Unsubscribe()
+
+	started := time.Now()
+	if err := c.node.AddPeer(url); err != nil {
+		a.Err = fmt.Errorf("failed to add Mail Server as a peer: %v", err)
+		return a
+	}
+
+	deadline := time.After(*connTimeout)
+	for a.Handshake == 0 {
+		select {
+		case ev := <-events:
+			if ev.Peer != n.ID() {
+				continue
+			}
+			switch ev.Type {
+			case p2p.PeerEventTypeAdd:
+				a.Dial = time.Since(started)
+			case p2p.PeerEventTypeMsgRecv:
+				if ev.Protocol == whisper.ProtocolName && ev.MsgCode != nil && *ev.MsgCode == whisperStatusCode {
+					a.Handshake = time.Since(started)
+				}
+			case p2p.PeerEventTypeDrop:
+				a.Err = fmt.Errorf("peer dropped: %s", ev.Error)
+				return a
+			}
+		case err := <-sub.Err():
+			a.Err = err
+			return a
+		case <-deadline:
+			a.Err = errors.New("timed out")
+			return a
+		}
+	}
+	return a
+}
+
+// connected returns true if the Mail Server is a peer of the client.
+func (c *benchClient) connected(n *enode.Node) bool {
+	for _, p := range c.node.Server().Peers() {
+		if p.ID() == n.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitConnected adds the Mail Server as a peer if needed
+// and waits until it is connected.
+func (c *benchClient) waitConnected(n *enode.Node, url string, timeout time.Duration) error {
+	events := make(chan *p2p.PeerEvent, 16)
+	sub := c.node.Server().SubscribeEvents(events)
+	defer sub.Unsubscribe()
+
+	if err := c.node.AddPeer(url); err != nil {
+		return fmt.Errorf("failed to add Mail Server as a peer: %v", err)
+	}
+	if c.connected(n) {
+		return nil
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Peer == n.ID() && ev.Type == p2p.PeerEventTypeAdd {
+				return nil
+			}
+		case err := <-sub.Err():
+			return err
+		case <-deadline:
+			return errors.New("timed out")
+		}
+	}
+}
+
+// disconnect removes the Mail Server from peers and waits until it is dropped
+// if it was connected.
+func (c *benchClient) disconnect(n *enode.Node) error {
+	events := make(chan *p2p.PeerEvent, 16)
+	sub := c.node.Server().SubscribeEvents(events)
+	defer sub.Unsubscribe()
+
+	connected := c.connected(n)
+	c.node.Server().RemovePeer(n)
+	if !connected {
+		return nil
+	}
+
+	deadline := time.After(*connTimeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Peer == n.ID() && ev.Type == p2p.PeerEventTypeDrop {
+				return nil
+			}
+		case err := <-sub.Err():
+			return err
+		case <-deadline:
+			return errors.New("timed out")
+		}
+	}
+}
+
+// connectSummary describes connections of all clients to a Mail Server.
+// Phases are summarized for successful attempts only.
+type connectSummary struct {
+	Attempts  int            `json:"attempts"`
+	Failures  int            `json:"failures"`
+	Dial      latencySummary `json:"dial"`
+	Handshake latencySummary `json:"handshake"`
+}
+
+func summarizeConnects(attempts []connectAttempt) connectSummary {
+	var dial, handshake []time.Duration
+	failures := 0
+	for _, a := range attempts {
+		if a.Err != nil {
+			failures++
+			continue
+		}
+		dial = append(dial, a.Dial)
+		handshake = append(handshake, a.Handshake)
+	}
+	return connectSummary{
+		Attempts:  len(attempts),
+		Failures:  failures,
+		Dial:      summarizeLatencies(dial),
+		Handshake: summarizeLatencies(handshake),
+	}
+}
+
+func (s connectSummary) String() string {
+	return fmt.Sprintf("attempts=%d failures=%d\n  dial:      %s\n  handshake: %s",
+		s.Attempts, s.Failures, s.Dial, s.Handshake)
+}
@@ -8,16 +8,54 @@ import (
 )
 
 var (
-	datadir     = pflag.StringP("datadir", "d", "", "directory for data")
-	address     = pflag.StringP("addr", "a", "127.0.0.1:30303", "listener IP address")
-	fleet       = pflag.StringP("fleet", "f", params.FleetBeta, "cluster fleet")
-	mailserver  = pflag.StringP("mailserver", "m", "", "MailServer address (by default a random one from the fleet is selected)")
-	concurrency = pflag.IntP("concurrency", "c", 5, "number of concurrent requests")
-	duration    = pflag.DurationP("duration", "l", time.Hour*24, "length of time span from now")
-	channel     = pflag.StringP("channel", "p", "status", "name of the channel")
-	verbosity   = pflag.StringP("verbosity", "v", "INFO", "verbosity level of status-go, options: crit, error, warning, info, debug")
+	datadir      = pflag.StringP("datadir", "d", "", "directory for data")
+	address      = pflag.StringP("addr", "a", "127.0.0.1:30303", "listener IP address")
+	fleet        = pflag.StringP("fleet", "f", params.FleetBeta, "cluster fleet")
+	mailservers  = pflag.StringArrayP("mailserver", "m", nil, "MailServer address, can be repeated to compare several (by default a random one from the fleet is selected)")
+	allServers   = pflag.Bool("all-mailservers", false, "benchmark all Mail Servers of the fleet and compare them")
+	parallel     = pflag.Bool("parallel", false, "benchmark several Mail Servers at the same time instead of one after another")
+	reconnects   = pflag.Int("reconnects", 0, "number of times each client reconnects to every Mail Server to measure connection setup")
+	connTimeout  = pflag.Duration("connect-timeout", 5*time.Second, "time to wait for a connection to a Mail Server including the Whisper handshake")
+	clientCount  = pflag.Int("clients", 1, "number of simulated clients, each a node with its own key and peer connections running the load profile")
+	concurrency  = pflag.IntP("concurrency", "c", 5, "number of concurrent requests of the burst profile")
+	profile      = pflag.String("profile", profileBurst, "load profile, options: burst, constant, ramp, spike, soak")
+	rate         = pflag.Float64("rate", 1, "requests per second of the constant, soak and spike profiles, and the initial rate of the ramp profile")
+	rampTo       = pflag.Float64("ramp-to", 10, "requests per second at the end of the ramp profile")
+	spikeRate    = pflag.Float64("spike-rate", 20, "requests per second during spikes of the spike profile")
+	spikeEvery   = pflag.Duration("spike-every", time.Minute, "period of spikes of the spike profile")
+	spikeLength  = pflag.Duration("spike-length", 10*time.Second, "length of spikes of the spike profile")
+	runTime      = pflag.Duration("run-time", time.Minute, "how long requests are sent by the rate profiles, 1h for soak unless set")
+	sliceTime    = pflag.Duration("slice", 10*time.Second, "length of time slices results are reported for, 5m for soak unless set")
+	duration     = pflag.DurationP("duration", "l", time.Hour*24, "length of time span from now")
+	limit        = pflag.Int("limit", 1000, "maximum number of envelopes returned for a single request")
+	syncMode     = pflag.Bool("sync", false, "follow cursors until the whole time span is fetched and measure throughput instead of using a load profile")
+	timeout      = pflag.DurationP("timeout", "t", time.Minute, "time after which a request without a signal is considered timed out")
+	channelNames = pflag.StringSliceP("channel", "p", []string{"status"}, "names of the channels to request history of, can be repeated")
+	channelsFile = pflag.String("channels-file", "", "file with more channel names to request history of, one per line")
+	topicCount   = pflag.Int("topics", 0, "number of topics in a request, the channel topics are truncated or padded with random ones (default the number of channels)")
+	verbosity    = pflag.StringP("verbosity", "v", "INFO", "verbosity level of status-go, options: crit, error, warning, info, debug")
+	output       = pflag.StringP("output", "o", "", "file to write the configuration and results to as JSON, or the sweep matrix, - for stdout")
+	baseline     = pflag.String("baseline", "", "results file of a previous run; the command exits with status 2 if latency or error rate regress")
+	latencyTol   = pflag.Float64("latency-tolerance", 0.2, "allowed relative increase of latency percentiles over the baseline")
+	errorRateTol = pflag.Float64("error-rate-tolerance", 0.01, "allowed absolute increase of the error rate over the baseline")
+	sweepLimits  = pflag.IntSlice("sweep-limits", nil, "limits to sweep over, e.g. 100,1000,10000")
+	sweepWindows = pflag.DurationSlice("sweep-windows", nil, "lengths of the time span to sweep over, e.g. 1h,24h,168h")
+	sweepTopics  = pflag.IntSlice("sweep-topics", nil, "numbers of topics in a request to sweep over")
+	sweepConc    = pflag.IntSlice("sweep-concurrency", nil, "numbers of concurrent requests to sweep over")
+	sweepClients = pflag.IntSlice("sweep-clients", nil, "numbers of clients sending requests to sweep over, up to --clients")
+	sweepFormat  = pflag.String("sweep-format", "csv", "format of the sweep matrix, options: csv, json")
+	metricsSinks = pflag.StringArray("metrics-sink", nil, "metrics sink for the results, e.g. pushgateway://host:9091, statsd://host:8125 or otlp://host:4318")
 )
 
 func init() {
 	pflag.Parse()
+
+	if *profile == profileSoak {
+		if !pflag.CommandLine.Changed("run-time") {
+			*runTime = soakRunTime
+		}
+		if !pflag.CommandLine.Changed("slice") {
+			*sliceTime = soakSliceTime
+		}
+	}
 }
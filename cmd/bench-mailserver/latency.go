@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Upper bounds of latency histogram buckets, in seconds.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type latencySummary struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func summarizeLatencies(latencies []time.Duration) latencySummary {
+	if len(latencies) == 0 {
+		return latencySummary{}
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return latencySummary{
+		Count: len(sorted),
+		Min:   sorted[0],
+		P50:   percentile(sorted, 0.5),
+		P90:   percentile(sorted, 0.9),
+		P99:   percentile(sorted, 0.99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+func (s latencySummary) String() string {
+	return fmt.Sprintf("count=%d min=%s p50=%s p90=%s p99=%s max=%s",
+		s.Count, round(s.Min), round(s.P50), round(s.P90), round(s.P99), round(s.Max))
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+// formatLatencyHistogram draws a text histogram with latencyBuckets.
+func formatLatencyHistogram(latencies []time.Duration) string {
+	const width = 40
+
+	counts := make([]int, len(latencyBuckets)+1)
+	for _, l := range latencies {
+		i := sort.SearchFloat64s(latencyBuckets, l.Seconds())
+		counts[i]++
+	}
+
+	max := 0
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+
+	var b strings.Builder
+	for i, c := range counts {
+		label := "+Inf"
+		if i < len(latencyBuckets) {
+			label = (time.Duration(latencyBuckets[i] * float64(time.Second))).String()
+		}
+		bar := 0
+		if max > 0 {
+			bar = c * width / max
+		}
+		fmt.Fprintf(&b, "  <= %-6s %5d %s\n", label, c, strings.Repeat("#", bar))
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ten := make([]time.Duration, 10)
+	for i := range ten {
+		ten[i] = time.Duration(i+1) * time.Second
+	}
+
+	for _, tc := range []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{"single value", []time.Duration{time.Second}, 0.5, time.Second},
+		{"zero percentile", ten, 0, time.Second},
+		{"median", ten, 0.5, 5 * time.Second},
+		{"nearest rank rounds up", ten, 0.55, 6 * time.Second},
+		{"p90", ten, 0.9, 9 * time.Second},
+		{"p99", ten, 0.99, 10 * time.Second},
+		{"maximum", ten, 1, 10 * time.Second},
+		{"two values p50", []time.Duration{time.Second, 3 * time.Second}, 0.5, time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := percentile(tc.sorted, tc.p); got != tc.want {
+				t.Errorf("percentile(%v, %v) = %s, want %s", tc.sorted, tc.p, got, tc.want)
+			}
+		})
+	}
+}
@@ -3,30 +3,21 @@ package main
 import (
 	"context"
 	"encoding/hex"
-	"encoding/json"
 	"log"
-	"math/rand"
 	"os"
 	stdsignal "os/signal"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/status-im/status-go/logutils"
-	"github.com/status-im/status-go/node"
-	"github.com/status-im/status-go/params"
-	"github.com/status-im/status-go/services/shhext"
 	"github.com/status-im/status-go/signal"
-	"github.com/status-im/status-go/t/helpers"
+	"github.com/status-im/statusd-bots/mailserver"
 	"github.com/status-im/statusd-bots/protocol"
 	"github.com/status-im/whisper/shhclient"
 	whisper "github.com/status-im/whisper/whisperv6"
 )
 
-var mailSignals = make(chan *signal.Envelope, 1)
-
 func init() {
 	if err := logutils.OverrideRootLog(true, *verbosity, "", false); err != nil {
 		log.Fatalf("failed to override root log: %v\n", err)
@@ -34,148 +25,212 @@ func init() {
 }
 
 func main() {
-	config, err := newNodeConfig(*address, *fleet, params.MainNetworkID)
+	load, err := newLoadProfile(*profile)
 	if err != nil {
-		log.Fatalf("failed to create a config: %v", err)
+		log.Fatalf("invalid load profile: %v", err)
 	}
-	log.Printf("using config: %v", config)
-
-	n := node.New()
-	if err := n.Start(config); err != nil {
-		log.Fatalf("failed to start a node: %v", err)
+	if *syncMode && *profile != profileBurst {
+		log.Fatalf("--sync can't be used with the %s profile", *profile)
 	}
-
-	rpcClient, err := n.GethNode().Attach()
+	channels, err := requestedChannels()
 	if err != nil {
-		log.Fatalf("failed to get an rpc: %v", err)
+		log.Fatalf("invalid channels: %v", err)
 	}
-	shh := shhclient.NewClient(rpcClient)
-
-	shhextService, err := n.ShhExtService()
+	topics, names, err := channelTopics(channels)
 	if err != nil {
-		log.Fatalf("failed go get an shhext service: %v", err)
+		log.Fatalf("invalid channels: %v", err)
 	}
-	shhextAPI := shhext.NewPublicAPI(shhextService)
-
-	signals := make(chan os.Signal, 1)
-	stdsignal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-
-	log.Println("subscribe for messages...")
-
-	symKeyID, err := addPublicChatSymKey(shh, *channel)
-	if err != nil {
-		log.Fatalf("failed to add sym key for channel '%s': %v", *channel, err)
+	if *topicCount > 0 {
+		topics = padTopics(topics, *topicCount)
 	}
-
-	messages := make(chan *whisper.Message)
-	sub, err := subscribeMessages(shh, *channel, symKeyID, messages)
+	sweep, err := newSweepAxes(len(topics))
 	if err != nil {
-		log.Fatalf("failed to subscribe to messages for channel '%s': %v", *channel, err)
+		log.Fatalf("invalid sweep: %v", err)
 	}
-	defer sub.Unsubscribe()
-
-	log.Println("adding Mail Server as a peer")
-
-	mailserverEnode := *mailserver
-	if mailserverEnode == "" {
-		mailserverEnode = config.ClusterConfig.TrustedMailServers[rand.Intn(len(config.ClusterConfig.TrustedMailServers))]
+	if err := checkComparison(); err != nil {
+		log.Fatalf("invalid options: %v", err)
 	}
-
-	if err := n.AddPeer(mailserverEnode); err != nil {
-		log.Fatalf("failed to add Mail Server as a peer: %v", err)
+	if err := checkClients(); err != nil {
+		log.Fatalf("invalid options: %v", err)
+	}
+	if *reconnects < 0 {
+		log.Fatalf("invalid options: --reconnects can't be negative")
 	}
 
-	errCh := helpers.WaitForPeerAsync(n.Server(), mailserverEnode, p2p.PeerEventTypeAdd, 5*time.Second)
-	if err := <-errCh; err != nil {
-		log.Fatalf("failed to wait for peer '%s': %v", mailserverEnode, err)
+	clients := make([]*benchClient, *clientCount)
+	for i := range clients {
+		c, err := startBenchClient(i, len(clients))
+		if err != nil {
+			log.Fatalf("failed to start client %d: %v", i, err)
+		}
+		clients[i] = c
 	}
 
-	log.Println("sending requests to Mail Server")
+	signals := make(chan os.Signal, 1)
+	stdsignal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Println("subscribe for messages...")
 
-	topic, err := protocol.PublicChatTopic([]byte(*channel))
-	if err != nil {
-		log.Fatalf("failed to get topic for channel %s: %v", *channel, err)
+	messages := make(chan clientMessage)
+	subErrs := make(chan error)
+	for _, c := range clients {
+		if err := c.Subscribe(channels, messages, subErrs); err != nil {
+			log.Fatalf("client %d: %v", c.ID, err)
+		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	mailServerSymKeyID, err := shh.GenerateSymmetricKeyFromPassword(ctx, protocol.MailServerPassword)
+	mailServers, err := selectMailServers(clients[0].config.ClusterConfig.TrustedMailServers)
 	if err != nil {
-		log.Fatalf("failed to generate sym key for mail server: %v", err)
+		log.Fatalf("failed to select Mail Servers: %v", err)
+	}
+
+	pacer := newConnectPacer()
+	attempts := make(map[string][]connectAttempt)
+	for _, c := range clients {
+		for _, server := range mailServers {
+			a, err := c.Connect(server, *reconnects, pacer)
+			if err != nil {
+				log.Fatalf("client %d: %v", c.ID, err)
+			}
+			attempts[server] = append(attempts[server], a...)
+		}
+	}
+	connections := make(map[string]*connectSummary)
+	for _, server := range mailServers {
+		s := summarizeConnects(attempts[server])
+		log.Printf("connections to %s: %s", server, s)
+		connections[server] = &s
 	}
 
-	// wait for all message requests
-	var wg sync.WaitGroup
+	sinks := startMetricsSinks()
 
-	// collect mail server request signals
-	mailSignals := make(chan *signal.Envelope)
-	counter := map[string]int{
-		signal.EventMailServerRequestCompleted: 0,
-		signal.EventMailServerRequestExpired:   0,
+	b := &benchmark{
+		clients:     clients,
+		names:       names,
+		connections: connections,
 	}
 
 	// setup signals handler
 	signal.SetDefaultNodeNotificationHandler(
-		filterNodeNotificationHandler(
-			printNodeNotificationHandler,
-			mailSignals,
-			[]string{
-				signal.EventMailServerRequestCompleted,
-				signal.EventMailServerRequestExpired,
-			},
-		),
+		mailserver.SignalHandler(printNodeNotificationHandler, b.HandleResponse),
 	)
 
-	// process mail signals
+	// run the benchmark and exit with its result
 	go func() {
-		for {
-			event := <-mailSignals
-			counter[event.Type]++
-			wg.Done()
+		var code int
+		if sweep != nil {
+			code = runSweep(b, sweep, mailServers[0], channels, topics)
+		} else {
+			code = runBenchmark(b, load, mailServers, channels, topics)
 		}
+		closeMetricsSinks(sinks)
+		os.Exit(code)
 	}()
 
-	// wait for all requests to finish and print result
-	go func() {
-		wg.Wait()
-		log.Printf("result: %v", counter)
-		os.Exit(0)
-	}()
-
-	// send mail server requests
-	for i := 0; i < *concurrency; i++ {
-		wg.Add(1)
-
-		go func() {
-			hash, err := shhextAPI.RequestMessages(nil, shhext.MessagesRequest{
-				MailServerPeer: mailserverEnode,
-				SymKeyID:       mailServerSymKeyID,
-				From:           uint32(time.Now().Add(-*duration).Unix()),
-				To:             uint32(time.Now().Unix()),
-				Limit:          1000,
-				Topic:          topic,
-				Timeout:        30,
-			})
-			if err != nil {
-				log.Fatalf("failed to request for messages: %v", err)
-			}
-			log.Printf("requested for messages with a request hash: %s", hash)
-		}()
-	}
-
 	for {
 		select {
 		case msg := <-messages:
 			source := hex.EncodeToString(msg.Sig)
-			log.Printf("received a message: topic=%v data=%s author=%s", msg.Topic, msg.Payload, source)
-		case err := <-sub.Err():
+			log.Printf("received a message: client=%d topic=%v data=%s author=%s", msg.client, msg.Topic, msg.Payload, source)
+			messagesCounter.Inc()
+			b.Deliver(msg.client, msg.Message)
+		case err := <-subErrs:
 			log.Fatalf("subscription error: %v", err)
 		case <-signals:
+			closeMetricsSinks(sinks)
 			os.Exit(1)
 		}
 	}
 }
 
+// runBenchmark runs the workload given with the flags against the Mail Servers
+// and returns the exit code. Several Mail Servers are compared with each other.
+func runBenchmark(b *benchmark, load loadProfile, mailServers []string, channels []string, topics []whisper.TopicType) int {
+	if *syncMode {
+		log.Println("syncing history from Mail Server")
+	} else {
+		log.Printf("sending requests to Mail Server with the %s profile", *profile)
+	}
+
+	w := workload{
+		clients:     b.clients,
+		mailServers: mailServers,
+		load:        load,
+		sync:        *syncMode,
+		topics:      topics,
+		window:      *duration,
+		limit:       *limit,
+	}
+	config := newBenchConfig(channels, len(topics))
+
+	var results []*benchResult
+	if len(mailServers) > 1 && *parallel {
+		config.Parallel = true
+		results = b.Run(w, config)
+	} else {
+		for _, server := range mailServers {
+			w.mailServers = []string{server}
+			results = append(results, b.Run(w, config)...)
+		}
+	}
+
+	if len(results) == 1 {
+		return reportBenchmark(results[0])
+	}
+
+	log.Printf("comparison of Mail Servers:\n%s", formatComparison(results))
+	if *output != "" {
+		if err := writeBenchResult(*output, results); err != nil {
+			log.Printf("failed to write results: %v", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// reportBenchmark writes the result of a single Mail Server
+// and compares it with the baseline.
+func reportBenchmark(result *benchResult) int {
+	if *output != "" {
+		if err := writeBenchResult(*output, result); err != nil {
+			log.Printf("failed to write results: %v", err)
+			return 1
+		}
+	}
+
+	if *baseline != "" {
+		return checkBaseline(result, *baseline)
+	}
+	return 0
+}
+
+// checkBaseline compares the result to the baseline and returns the exit
+// code, which is 2 if it regressed and 1 if it can't be compared.
+func checkBaseline(result *benchResult, path string) int {
+	base, err := readBenchResult(path)
+	if err != nil {
+		log.Printf("failed to read the baseline: %v", err)
+		return 1
+	}
+
+	regressions, err := compareWithBaseline(result, base, benchTolerances{
+		Latency:   *latencyTol,
+		ErrorRate: *errorRateTol,
+	})
+	if err != nil {
+		log.Printf("failed to compare with %s: %v", path, err)
+		return 1
+	}
+	if len(regressions) == 0 {
+		log.Printf("no regressions compared to %s", path)
+		return 0
+	}
+	for _, r := range regressions {
+		log.Printf("regression: %s", r)
+	}
+	return 2
+}
+
 func addPublicChatSymKey(c *shhclient.Client, chat string) (string, error) {
 	// This operation can be really slow, hence 10 seconds timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -202,22 +257,3 @@ func subscribeMessages(c *shhclient.Client, chat, symKeyID string, messages chan
 func printNodeNotificationHandler(event string) {
 	log.Printf("received signal: %v\n", event)
 }
-
-func filterNodeNotificationHandler(
-	fn func(string), in chan<- *signal.Envelope, types []string,
-) func(string) {
-	return func(event string) {
-		fn(event)
-
-		var envelope signal.Envelope
-		if err := json.Unmarshal([]byte(event), &envelope); err != nil {
-			log.Fatalf("faild to unmarshal signal Envelope: %s", err)
-		}
-
-		for _, typ := range types {
-			if typ == envelope.Type {
-				in <- &envelope
-			}
-		}
-	}
-}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/status-im/statusd-bots/metrics"
+)
+
+var (
+	requestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mailserver_bench",
+		Name:      "requests_total",
+		Help:      "Finished requests by the Mail Server and their outcome.",
+	}, []string{"mailserver", "outcome"})
+	unmatchedSignalsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mailserver_bench",
+		Name:      "unmatched_signals_total",
+		Help:      "Mail server signals which did not match any pending request.",
+	})
+	messagesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mailserver_bench",
+		Name:      "messages_total",
+		Help:      "Messages received from the Mail Server.",
+	})
+	receivedBytesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mailserver_bench",
+		Name:      "received_bytes_total",
+		Help:      "Size of envelope data of messages received from the Mail Server.",
+	})
+	lateEnvelopesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mailserver_bench",
+		Name:      "late_envelopes_total",
+		Help:      "Envelopes delivered after their request finished.",
+	})
+	duplicateEnvelopesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mailserver_bench",
+		Name:      "duplicate_envelopes_total",
+		Help:      "Envelopes delivered more than once.",
+	})
+	syncPagesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mailserver_bench",
+		Name:      "sync_pages_total",
+		Help:      "Pages requested while following cursors.",
+	})
+	syncDurationGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mailserver_bench",
+		Name:      "sync_duration_seconds",
+		Help:      "Time it took to fetch the whole time span following cursors.",
+	})
+	requestDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mailserver_bench",
+		Name:      "request_duration_seconds",
+		Help:      "Time from sending a request to its completion signal.",
+		Buckets:   latencyBuckets,
+	}, []string{"mailserver"})
+	connectDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mailserver_bench",
+		Name:      "connect_duration_seconds",
+		Help:      "Time to each phase of connecting to the Mail Server: dial and handshake.",
+		Buckets:   latencyBuckets,
+	}, []string{"mailserver", "phase"})
+	targetRateGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mailserver_bench",
+		Name:      "target_rate",
+		Help:      "Requests per second the load profile aims for.",
+	})
+	durationGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mailserver_bench",
+		Name:      "duration_seconds",
+		Help:      "Time it took to finish all requests.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(requestsCounter)
+	prometheus.MustRegister(unmatchedSignalsCounter)
+	prometheus.MustRegister(messagesCounter)
+	prometheus.MustRegister(receivedBytesCounter)
+	prometheus.MustRegister(lateEnvelopesCounter)
+	prometheus.MustRegister(duplicateEnvelopesCounter)
+	prometheus.MustRegister(syncPagesCounter)
+	prometheus.MustRegister(syncDurationGauge)
+	prometheus.MustRegister(requestDurationHistogram)
+	prometheus.MustRegister(connectDurationHistogram)
+	prometheus.MustRegister(targetRateGauge)
+	prometheus.MustRegister(durationGauge)
+}
+
+func startMetricsSinks() metrics.Sinks {
+	sinks, err := metrics.StartSinks(*metricsSinks, "bench-mailserver", prometheus.DefaultGatherer)
+	if err != nil {
+		log.Fatalf("failed to start metrics sinks: %v", err)
+	}
+	return sinks
+}
+
+// closeMetricsSinks pushes the results for the last time before the process exits.
+func closeMetricsSinks(sinks metrics.Sinks) {
+	if err := sinks.Close(); err != nil {
+		log.Printf("failed to close metrics sinks: %v", err)
+	}
+}
+
+// mailServerLabel shortens the enode to the IP address and the port.
+func mailServerLabel(msEnode string) string {
+	n, err := enode.ParseV4(msEnode)
+	if err != nil {
+		return msEnode
+	}
+	return fmt.Sprintf("%s:%d", n.IP(), n.TCP())
+}
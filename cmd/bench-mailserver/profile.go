@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Load profiles.
+const (
+	profileBurst    = "burst"    // --concurrency requests at once
+	profileConstant = "constant" // --rate requests per second for --run-time
+	profileRamp     = "ramp"     // from --rate to --ramp-to requests per second over --run-time
+	profileSpike    = "spike"    // --rate with --spike-rate for --spike-length every --spike-every
+	profileSoak     = "soak"     // constant with a long --run-time and wide result slices
+)
+
+// Defaults of the soak profile used if the flags are not set.
+const (
+	soakRunTime   = time.Hour
+	soakSliceTime = 5 * time.Minute
+)
+
+// loadProfile decides when requests are sent.
+type loadProfile interface {
+	// Run calls send for every request and returns when the profile ends.
+	Run(send func())
+}
+
+// burstProfile sends all requests at once.
+type burstProfile struct {
+	requests int
+}
+
+func (p burstProfile) Run(send func()) {
+	for i := 0; i < p.requests; i++ {
+		send()
+	}
+}
+
+// rateProfile sends requests with a rate which changes over time.
+type rateProfile struct {
+	length time.Duration
+	rate   func(elapsed time.Duration) float64 // requests per second
+}
+
+func (p rateProfile) Run(send func()) {
+	// How often the rate is checked when it is zero.
+	const idle = 100 * time.Millisecond
+
+	started := time.Now()
+	next := time.Duration(0)
+	for next < p.length {
+		time.Sleep(time.Until(started.Add(next)))
+
+		rate := p.rate(next)
+		targetRateGauge.Set(rate)
+		if rate <= 0 {
+			next += idle
+			continue
+		}
+
+		send()
+		next += time.Duration(float64(time.Second) / rate)
+	}
+	targetRateGauge.Set(0)
+}
+
+// newLoadProfile creates a profile by its name from the flags.
+func newLoadProfile(name string) (loadProfile, error) {
+	if name != profileBurst && *rate <= 0 {
+		return nil, fmt.Errorf("--rate must be positive")
+	}
+
+	switch name {
+	case profileBurst:
+		return burstProfile{requests: *concurrency}, nil
+	case profileConstant, profileSoak:
+		return rateProfile{
+			length: *runTime,
+			rate:   func(time.Duration) float64 { return *rate },
+		}, nil
+	case profileRamp:
+		from, to, length := *rate, *rampTo, *runTime
+		return rateProfile{
+			length: length,
+			rate: func(elapsed time.Duration) float64 {
+				return from + (to-from)*float64(elapsed)/float64(length)
+			},
+		}, nil
+	case profileSpike:
+		if *spikeEvery <= 0 || *spikeLength > *spikeEvery {
+			return nil, fmt.Errorf("--spike-length must not exceed a positive --spike-every")
+		}
+		base, spike, every, length := *rate, *spikeRate, *spikeEvery, *spikeLength
+		return rateProfile{
+			length: *runTime,
+			rate: func(elapsed time.Duration) float64 {
+				// Spikes start at the end of every period, so the first
+				// one is preceded by the base load.
+				if elapsed%every >= every-length {
+					return spike
+				}
+				return base
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown profile '%s', options: %s", name,
+		strings.Join([]string{profileBurst, profileConstant, profileRamp, profileSpike, profileSoak}, ", "))
+}
+
+// sliceResult holds results of requests sent within a time slice of the run.
+type sliceResult struct {
+	Start    time.Duration  `json:"start"` // since the start of the run
+	Sent     int            `json:"sent"`
+	Outcomes map[string]int `json:"outcomes"`
+	Latency  latencySummary `json:"latency"`
+}
+
+// sliceResults groups requests by the time they were sent.
+func sliceResults(requests []*trackedRequest, started time.Time, slice time.Duration) []sliceResult {
+	var (
+		results  []sliceResult
+		selected []*trackedRequest
+	)
+	flush := func(i int) {
+		if len(selected) == 0 {
+			return
+		}
+		results = append(results, sliceResult{
+			Start:    time.Duration(i) * slice,
+			Sent:     len(selected),
+			Outcomes: countOutcomes(selected),
+			Latency:  summarizeLatencies(completedLatencies(selected)),
+		})
+		selected = nil
+	}
+
+	// Requests are ordered by the time they were sent.
+	current := 0
+	for _, r := range requests {
+		i := int(r.SentAt.Sub(started) / slice)
+		if i != current {
+			flush(current)
+			current = i
+		}
+		selected = append(selected, r)
+	}
+	flush(current)
+
+	return results
+}
+
+// formatSliceResults draws a table with a row per slice.
+func formatSliceResults(results []sliceResult, slice time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %-10s %6s %8s", "slice", "sent", "rps")
+	for _, o := range outcomes {
+		fmt.Fprintf(&b, " %9s", o)
+	}
+	fmt.Fprintf(&b, " %9s %9s %9s\n", "p50", "p90", "p99")
+
+	for _, r := range results {
+		fmt.Fprintf(&b, "  %-10s %6d %8.2f", r.Start, r.Sent, float64(r.Sent)/slice.Seconds())
+		for _, o := range outcomes {
+			fmt.Fprintf(&b, " %9d", r.Outcomes[o])
+		}
+		fmt.Fprintf(&b, " %9s %9s %9s\n", round(r.Latency.P50), round(r.Latency.P90), round(r.Latency.P99))
+	}
+	return b.String()
+}
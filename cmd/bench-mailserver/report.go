@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// Durations in the results are in nanoseconds.
+
+// benchConfig is the configuration of a run.
+type benchConfig struct {
+	Fleet       string        `json:"fleet"`
+	MailServer  string        `json:"mailServer"`
+	Parallel    bool          `json:"parallel,omitempty"` // with other mail servers
+	Clients     int           `json:"clients,omitempty"`  // if there are several
+	Channels    []string      `json:"channels"`
+	Profile     string        `json:"profile"`
+	Concurrency int           `json:"concurrency,omitempty"`
+	Rate        float64       `json:"rate,omitempty"`
+	RampTo      float64       `json:"rampTo,omitempty"`
+	SpikeRate   float64       `json:"spikeRate,omitempty"`
+	SpikeEvery  time.Duration `json:"spikeEvery,omitempty"`
+	SpikeLength time.Duration `json:"spikeLength,omitempty"`
+	RunTime     time.Duration `json:"runTime,omitempty"`
+	Slice       time.Duration `json:"slice,omitempty"`
+	Sync        bool          `json:"sync"`
+	Duration    time.Duration `json:"duration"`
+	Limit       int           `json:"limit"`
+	Topics      int           `json:"topics"`
+	Timeout     time.Duration `json:"timeout"`
+}
+
+// newBenchConfig returns the configuration from the flags.
+// Options of other profiles are left out.
+func newBenchConfig(channels []string, topics int) benchConfig {
+	c := benchConfig{
+		Fleet:    *fleet,
+		Channels: channels,
+		Profile:  *profile,
+		Sync:     *syncMode,
+		Duration: *duration,
+		Limit:    *limit,
+		Topics:   topics,
+		Timeout:  *timeout,
+	}
+	if *syncMode {
+		c.Profile = ""
+		return c
+	}
+
+	switch *profile {
+	case profileBurst:
+		c.Concurrency = *concurrency
+	case profileConstant, profileSoak:
+		c.Rate, c.RunTime = *rate, *runTime
+	case profileRamp:
+		c.Rate, c.RampTo, c.RunTime = *rate, *rampTo, *runTime
+	case profileSpike:
+		c.Rate, c.SpikeRate, c.SpikeEvery, c.SpikeLength, c.RunTime = *rate, *spikeRate, *spikeEvery, *spikeLength, *runTime
+	}
+	if *profile != profileBurst {
+		c.Slice = *sliceTime
+	}
+	return c
+}
+
+// benchResult is the configuration and results of a run.
+type benchResult struct {
+	Config           benchConfig      `json:"config"`
+	StartedAt        time.Time        `json:"startedAt"`
+	Duration         time.Duration    `json:"duration"`
+	Requests         int              `json:"requests"`
+	Outcomes         map[string]int   `json:"outcomes"`
+	ErrorRate        float64          `json:"errorRate"` // requests which did not complete
+	UnmatchedSignals int              `json:"unmatchedSignals"`
+	Latency          latencySummary   `json:"latency"`
+	Deliveries       *deliverySummary `json:"deliveries,omitempty"` // unless run in parallel
+	Sync             *syncResult      `json:"sync,omitempty"`
+	Slices           []sliceResult    `json:"slices,omitempty"`
+	Clients          []clientResult   `json:"clients,omitempty"`
+	Connections      *connectSummary  `json:"connections,omitempty"` // of all clients
+}
+
+func newBenchResult(config benchConfig, started time.Time, requests []*trackedRequest, tracker *requestTracker, names map[whisper.TopicType]string) *benchResult {
+	deliveries := tracker.Deliveries(requests, names)
+	r := &benchResult{
+		Config:           config,
+		StartedAt:        started.UTC(),
+		Duration:         time.Since(started),
+		Requests:         len(requests),
+		Outcomes:         countOutcomes(requests),
+		UnmatchedSignals: tracker.Unmatched(),
+		Latency:          summarizeLatencies(completedLatencies(requests)),
+		Deliveries:       &deliveries,
+	}
+	r.ErrorRate = errorRate(r.Requests, r.Outcomes)
+	if config.Slice > 0 {
+		r.Slices = sliceResults(requests, started, config.Slice)
+	}
+	if config.Clients > 1 {
+		r.Clients = clientResults(requests, config.Clients)
+	}
+	return r
+}
+
+// errorRate returns the share of requests which did not complete.
+func errorRate(requests int, outcomes map[string]int) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(requests-outcomes[outcomeCompleted]) / float64(requests)
+}
+
+// writeBenchResult writes results as JSON to the path, or stdout if it is "-".
+func writeBenchResult(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func readBenchResult(path string) (*benchResult, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r benchResult
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &r, nil
+}
+
+// benchTolerances limit how much worse a run can be than the baseline.
+type benchTolerances struct {
+	Latency   float64 // relative increase of latency percentiles
+	ErrorRate float64 // absolute increase of the error rate
+}
+
+// compareWithBaseline returns descriptions of regressions beyond the tolerances.
+// It returns an error if the baseline was measured with a different workload.
+func compareWithBaseline(current, baseline *benchResult, tol benchTolerances) ([]string, error) {
+	if diff := workloadDiff(current.Config, baseline.Config); len(diff) > 0 {
+		return nil, fmt.Errorf("the baseline was measured with a different workload: %s", strings.Join(diff, ", "))
+	}
+
+	var regressions []string
+
+	if current.ErrorRate > baseline.ErrorRate+tol.ErrorRate {
+		regressions = append(regressions, fmt.Sprintf("error rate %.4f exceeds baseline %.4f by more than %.4f",
+			current.ErrorRate, baseline.ErrorRate, tol.ErrorRate))
+	}
+
+	// Latency can't regress if nothing completed, which is an error rate regression.
+	if baseline.Latency.Count == 0 || current.Latency.Count == 0 {
+		return regressions, nil
+	}
+	for _, p := range []struct {
+		name              string
+		current, baseline time.Duration
+	}{
+		{"p50", current.Latency.P50, baseline.Latency.P50},
+		{"p90", current.Latency.P90, baseline.Latency.P90},
+		{"p99", current.Latency.P99, baseline.Latency.P99},
+	} {
+		limit := time.Duration(float64(p.baseline) * (1 + tol.Latency))
+		if p.current > limit {
+			regressions = append(regressions, fmt.Sprintf("%s latency %s exceeds baseline %s by more than %.0f%%",
+				p.name, round(p.current), round(p.baseline), tol.Latency*100))
+		}
+	}
+
+	return regressions, nil
+}
+
+// workloadDiff returns the fields of the configurations which differ,
+// as "name: current (baseline was baseline)". The Mail Server is not
+// a part of the workload, as it may be picked at random from the fleet.
+func workloadDiff(current, baseline benchConfig) []string {
+	current.MailServer, baseline.MailServer = "", ""
+
+	var diff []string
+	cv, bv := reflect.ValueOf(current), reflect.ValueOf(baseline)
+	for i := 0; i < cv.NumField(); i++ {
+		c, b := cv.Field(i).Interface(), bv.Field(i).Interface()
+		if reflect.DeepEqual(c, b) {
+			continue
+		}
+		name := strings.Split(cv.Type().Field(i).Tag.Get("json"), ",")[0]
+		diff = append(diff, fmt.Sprintf("%s: %v (baseline was %v)", name, c, b))
+	}
+	return diff
+}
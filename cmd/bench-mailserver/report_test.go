@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorRate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		requests int
+		outcomes map[string]int
+		want     float64
+	}{
+		{"no requests", 0, nil, 0},
+		{"all completed", 4, map[string]int{outcomeCompleted: 4}, 0},
+		{"none completed", 4, map[string]int{outcomeTimeout: 3, outcomeError: 1}, 1},
+		{"some completed", 4, map[string]int{outcomeCompleted: 3, outcomeExpired: 1}, 0.25},
+		{"unknown outcomes", 2, map[string]int{outcomeCompleted: 1}, 0.5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := errorRate(tc.requests, tc.outcomes); got != tc.want {
+				t.Errorf("errorRate(%d, %v) = %v, want %v", tc.requests, tc.outcomes, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompareWithBaseline(t *testing.T) {
+	config := benchConfig{
+		Fleet:       "eth.beta",
+		MailServer:  "enode://a@127.0.0.1:30303",
+		Channels:    []string{"status"},
+		Profile:     profileBurst,
+		Concurrency: 5,
+		Duration:    24 * time.Hour,
+		Limit:       1000,
+		Topics:      1,
+		Timeout:     time.Minute,
+	}
+	result := func(errorRate float64, p50, p90, p99 time.Duration) *benchResult {
+		return &benchResult{
+			Config:    config,
+			ErrorRate: errorRate,
+			Latency:   latencySummary{Count: 100, P50: p50, P90: p90, P99: p99},
+		}
+	}
+	base := result(0.01, 100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond)
+	tol := benchTolerances{Latency: 0.2, ErrorRate: 0.01}
+
+	otherServer := result(0.01, 100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond)
+	otherServer.Config.MailServer = "enode://b@127.0.0.1:30303"
+	otherLimit := result(0.01, 100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond)
+	otherLimit.Config.Limit = 100
+	nothingCompleted := &benchResult{Config: config, ErrorRate: 1}
+
+	for _, tc := range []struct {
+		name        string
+		current     *benchResult
+		regressions []string // prefixes
+		err         string
+	}{
+		{
+			name:    "same",
+			current: result(0.01, 100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond),
+		},
+		{
+			name:    "within tolerances",
+			current: result(0.02, 120*time.Millisecond, 240*time.Millisecond, 480*time.Millisecond),
+		},
+		{
+			name:        "error rate",
+			current:     result(0.03, 100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond),
+			regressions: []string{"error rate"},
+		},
+		{
+			name:        "latency",
+			current:     result(0.01, 100*time.Millisecond, 300*time.Millisecond, 500*time.Millisecond),
+			regressions: []string{"p90 latency", "p99 latency"},
+		},
+		{
+			name:        "nothing completed",
+			current:     nothingCompleted,
+			regressions: []string{"error rate"},
+		},
+		{
+			name:    "other mail server",
+			current: otherServer,
+		},
+		{
+			name:    "other workload",
+			current: otherLimit,
+			err:     "different workload: limit: 100 (baseline was 1000)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			regressions, err := compareWithBaseline(tc.current, base, tol)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(regressions) != len(tc.regressions) {
+				t.Fatalf("expected regressions %q, got %q", tc.regressions, regressions)
+			}
+			for i, prefix := range tc.regressions {
+				if !strings.HasPrefix(regressions[i], prefix) {
+					t.Errorf("expected regression starting with %q, got %q", prefix, regressions[i])
+				}
+			}
+		})
+	}
+}
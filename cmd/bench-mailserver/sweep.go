@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// sweepAxes are values of request parameters which are benchmarked
+// in every combination.
+type sweepAxes struct {
+	Limits      []int
+	Windows     []time.Duration
+	Topics      []int
+	Concurrency []int
+	Clients     []int
+}
+
+// newSweepAxes returns nil if no sweep flag is set. Parameters
+// without a sweep flag take the single value of their regular flag.
+func newSweepAxes(topics int) (*sweepAxes, error) {
+	if len(*sweepLimits) == 0 && len(*sweepWindows) == 0 && len(*sweepTopics) == 0 && len(*sweepConc) == 0 && len(*sweepClients) == 0 {
+		return nil, nil
+	}
+	if *syncMode || *profile != profileBurst {
+		return nil, errors.New("a sweep runs bursts of requests and can't be used with --sync or --profile")
+	}
+	if *baseline != "" {
+		return nil, errors.New("a sweep can't be compared with --baseline")
+	}
+	if len(*mailservers) > 1 || *allServers {
+		return nil, errors.New("a sweep runs against a single Mail Server")
+	}
+
+	axes := &sweepAxes{
+		Limits:      *sweepLimits,
+		Windows:     *sweepWindows,
+		Topics:      *sweepTopics,
+		Concurrency: *sweepConc,
+		Clients:     *sweepClients,
+	}
+	if len(axes.Limits) == 0 {
+		axes.Limits = []int{*limit}
+	}
+	if len(axes.Windows) == 0 {
+		axes.Windows = []time.Duration{*duration}
+	}
+	if len(axes.Topics) == 0 {
+		axes.Topics = []int{topics}
+	}
+	if len(axes.Concurrency) == 0 {
+		axes.Concurrency = []int{*concurrency}
+	}
+	if len(axes.Clients) == 0 {
+		axes.Clients = []int{*clientCount}
+	}
+
+	for _, n := range axes.Topics {
+		if n < 1 {
+			return nil, errors.New("topic counts must be positive")
+		}
+	}
+	for _, n := range axes.Clients {
+		if n < 1 || n > *clientCount {
+			return nil, fmt.Errorf("client counts must be between 1 and %d", *clientCount)
+		}
+	}
+
+	switch *sweepFormat {
+	case "csv", "json":
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", *sweepFormat)
+	}
+
+	return axes, nil
+}
+
+// Size returns the number of combinations.
+func (a *sweepAxes) Size() int {
+	return len(a.Limits) * len(a.Windows) * len(a.Topics) * len(a.Concurrency) * len(a.Clients)
+}
+
+// runSweep runs a burst of requests for every combination of the axes
+// and writes the result matrix to --output. It returns the exit code.
+// A number of clients is taken from the beginning of the started ones.
+func runSweep(b *benchmark, axes *sweepAxes, mailServer string, channels []string, topics []whisper.TopicType) int {
+	var results []*benchResult
+
+	i := 0
+	for _, l := range axes.Limits {
+		for _, w := range axes.Windows {
+			for _, t := range axes.Topics {
+				for _, c := range axes.Concurrency {
+					for _, n := range axes.Clients {
+						i++
+						log.Printf("sweep %d/%d: limit=%d window=%s topics=%d concurrency=%d clients=%d",
+							i, axes.Size(), l, w, t, c, n)
+
+						config := newBenchConfig(channels, t)
+						config.Limit, config.Duration, config.Concurrency = l, w, c
+
+						results = append(results, b.Run(workload{
+							clients:     b.clients[:n],
+							mailServers: []string{mailServer},
+							load:        burstProfile{requests: c},
+							topics:      padTopics(topics, t),
+							window:      w,
+							limit:       l,
+						}, config)...)
+					}
+				}
+			}
+		}
+	}
+
+	write := writeSweepCSV
+	if *sweepFormat == "json" {
+		write = writeSweepJSON
+	}
+
+	out := os.Stdout
+	if *output != "" && *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Printf("failed to write results: %v", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := write(out, results); err != nil {
+		log.Printf("failed to write results: %v", err)
+		return 1
+	}
+	return 0
+}
+
+func writeSweepJSON(w io.Writer, results []*benchResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// writeSweepCSV writes a row per combination. Latencies are in milliseconds.
+func writeSweepCSV(w io.Writer, results []*benchResult) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"limit", "window", "topics", "concurrency", "clients", "requests"}
+	header = append(header, outcomes...)
+	header = append(header, "error_rate", "p50_ms", "p90_ms", "p99_ms", "max_ms", "envelopes", "bytes", "duration_ms")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	ms := func(d time.Duration) string {
+		return strconv.FormatInt(int64(d/time.Millisecond), 10)
+	}
+	for _, r := range results {
+		clients := r.Config.Clients
+		if clients == 0 {
+			clients = 1
+		}
+		record := []string{
+			strconv.Itoa(r.Config.Limit),
+			r.Config.Duration.String(),
+			strconv.Itoa(r.Config.Topics),
+			strconv.Itoa(r.Config.Concurrency),
+			strconv.Itoa(clients),
+			strconv.Itoa(r.Requests),
+		}
+		for _, o := range outcomes {
+			record = append(record, strconv.Itoa(r.Outcomes[o]))
+		}
+		record = append(record,
+			strconv.FormatFloat(r.ErrorRate, 'f', 4, 64),
+			ms(r.Latency.P50), ms(r.Latency.P90), ms(r.Latency.P99), ms(r.Latency.Max),
+			strconv.Itoa(r.Deliveries.Envelopes),
+			strconv.Itoa(r.Deliveries.Bytes),
+			ms(r.Duration),
+		)
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// Envelopes are delivered before the request is completed, but they
+// are decrypted asynchronously, so the last ones may arrive a bit later.
+const deliveryGrace = 2 * time.Second
+
+// deliveryCounter counts messages delivered by the mail server.
+type deliveryCounter struct {
+	envelopes int64
+	bytes     int64
+}
+
+// Add counts the message and returns its size.
+func (c *deliveryCounter) Add(msg *whisper.Message) int {
+	size := messageSize(msg)
+	atomic.AddInt64(&c.envelopes, 1)
+	atomic.AddInt64(&c.bytes, int64(size))
+	return size
+}
+
+// Load returns the number and size of delivered messages.
+func (c *deliveryCounter) Load() (envelopes, bytes int64) {
+	return atomic.LoadInt64(&c.envelopes), atomic.LoadInt64(&c.bytes)
+}
+
+// messageSize is the size of the envelope data, which is the payload with padding.
+func messageSize(msg *whisper.Message) int {
+	return len(msg.Payload) + len(msg.Padding)
+}
+
+// syncResult is the cost of fetching the whole history of a channel.
+type syncResult struct {
+	Pages     int           `json:"pages"`
+	Envelopes int64         `json:"envelopes"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+	Complete  bool          `json:"complete"` // false if a page request did not complete
+}
+
+func (r syncResult) String() string {
+	seconds := r.Duration.Seconds()
+	if seconds == 0 {
+		seconds = 1
+	}
+	return fmt.Sprintf("complete=%t pages=%d envelopes=%d bytes=%d time=%s pages/s=%.2f envelopes/s=%.2f bytes/s=%.0f",
+		r.Complete, r.Pages, r.Envelopes, r.Bytes, round(r.Duration),
+		float64(r.Pages)/seconds, float64(r.Envelopes)/seconds, float64(r.Bytes)/seconds)
+}
+
+// syncHistory requests pages following the cursor of the previous one
+// until the mail server returns no cursor or a page fails.
+func syncHistory(request func(cursor string) *trackedRequest, delivered *deliveryCounter) syncResult {
+	started := time.Now()
+	envelopes, bytes := delivered.Load()
+
+	var result syncResult
+	for cursor := ""; ; {
+		r := request(cursor)
+		<-r.Done()
+		result.Pages++
+		syncPagesCounter.Inc()
+
+		if r.Outcome != outcomeCompleted {
+			break
+		}
+		if r.Cursor == "" {
+			result.Complete = true
+			break
+		}
+		cursor = r.Cursor
+	}
+	result.Duration = time.Since(started)
+
+	time.Sleep(deliveryGrace)
+	e, b := delivered.Load()
+	result.Envelopes, result.Bytes = e-envelopes, b-bytes
+
+	return result
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/status-im/statusd-bots/protocol"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// requestedChannels returns --channel names followed by the ones from --channels-file.
+func requestedChannels() ([]string, error) {
+	channels := append([]string(nil), *channelNames...)
+	if *channelsFile != "" {
+		f, err := os.Open(*channelsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if name := strings.TrimSpace(scanner.Text()); name != "" && !strings.HasPrefix(name, "#") {
+				channels = append(channels, name)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channels given")
+	}
+	return channels, nil
+}
+
+// channelTopics returns topics of the channels and channel names by topic.
+func channelTopics(channels []string) ([]whisper.TopicType, map[whisper.TopicType]string, error) {
+	var topics []whisper.TopicType
+	names := make(map[whisper.TopicType]string)
+	for _, chat := range channels {
+		topic, err := protocol.PublicChatTopic([]byte(chat))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get topic for channel %s: %v", chat, err)
+		}
+		if _, ok := names[topic]; ok {
+			continue
+		}
+		topics = append(topics, topic)
+		names[topic] = chat
+	}
+	return topics, names, nil
+}
+
+// padTopics returns the first n topics, adding random topics if there
+// are less of them. Random topics have no envelopes, but the mail server
+// still has to match envelopes against them.
+func padTopics(topics []whisper.TopicType, n int) []whisper.TopicType {
+	if n <= len(topics) {
+		return topics[:n]
+	}
+	result := append([]whisper.TopicType(nil), topics...)
+	for len(result) < n {
+		var t whisper.TopicType
+		rand.Read(t[:])
+		result = append(result, t)
+	}
+	return result
+}
+
+// bloomStats returns the number of bits set in the bloom filter
+// the topics are squashed into, and the probability that an envelope
+// of another topic matches it.
+func bloomStats(topics []whisper.TopicType) (int, float64) {
+	bloom := make([]byte, whisper.BloomFilterSize)
+	for _, t := range topics {
+		for i, b := range whisper.TopicToBloom(t) {
+			bloom[i] |= b
+		}
+	}
+
+	set := 0
+	for _, b := range bloom {
+		set += bits.OnesCount8(b)
+	}
+	// Every topic sets three bits of the filter.
+	return set, math.Pow(float64(set)/float64(whisper.BloomFilterSize*8), 3)
+}
+
+// formatTopicCounts lists envelopes per topic, named by their channels.
+func formatTopicCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(parts, " ")
+}
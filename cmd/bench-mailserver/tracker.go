@@ -0,0 +1,266 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/status-im/statusd-bots/mailserver"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// Outcomes of a tracked request.
+const (
+	outcomeCompleted = mailserver.OutcomeCompleted
+	outcomeFailed    = mailserver.OutcomeFailed
+	outcomeExpired   = mailserver.OutcomeExpired
+	outcomeTimeout   = "timeout" // no signal within the tracker timeout
+	outcomeError     = "error"   // the request could not be sent
+)
+
+var outcomes = []string{outcomeCompleted, outcomeFailed, outcomeExpired, outcomeTimeout, outcomeError}
+
+// requestParams describe which envelopes are requested.
+type requestParams struct {
+	Client     int // which sent the request
+	MailServer string
+	Topics     []whisper.TopicType
+	From       time.Time
+	To         time.Time
+}
+
+// trackedRequest is a request sent to a mail server and its outcome.
+type trackedRequest struct {
+	requestParams
+
+	Hash             string
+	SentAt           time.Time
+	FinishedAt       time.Time
+	Outcome          string
+	Cursor           string
+	LastEnvelopeHash string
+	Error            string
+
+	// Envelopes attributed to the request.
+	Envelopes  int
+	Bytes      int
+	Late       int // delivered after the request finished
+	Duplicates int // delivered before, possibly for another request
+
+	done chan struct{}
+}
+
+func newTrackedRequest(hash string, sentAt time.Time, p requestParams) *trackedRequest {
+	return &trackedRequest{requestParams: p, Hash: hash, SentAt: sentAt, done: make(chan struct{})}
+}
+
+// Done is closed when the request has finished.
+func (r *trackedRequest) Done() <-chan struct{} {
+	return r.done
+}
+
+// Latency returns the time from sending the request to its signal.
+func (r *trackedRequest) Latency() time.Duration {
+	return r.FinishedAt.Sub(r.SentAt)
+}
+
+// requestTracker matches mail server signals with sent requests by their hashes.
+// Requests without a signal are timed out on the tracker clock. Signals can
+// arrive before RequestMessages returns the hash, so they are kept for
+// the timeout too; signals not matched by then are counted as unmatched.
+type requestTracker struct {
+	timeout time.Duration
+
+	mu        sync.Mutex
+	expected  int
+	matcher   *mailserver.Matcher
+	pending   map[string]*trackedRequest
+	finished  []*trackedRequest
+	unmatched int
+	delivered map[string]int            // client and envelope hash => times delivered
+	orphans   int                       // envelopes not attributed to any request
+	topics    map[whisper.TopicType]int // attributed envelopes per topic
+	sentAll   bool
+	done      chan struct{}
+	closed    bool
+}
+
+func newRequestTracker(timeout time.Duration) *requestTracker {
+	return &requestTracker{
+		timeout:   timeout,
+		matcher:   mailserver.NewMatcher(),
+		pending:   make(map[string]*trackedRequest),
+		delivered: make(map[string]int),
+		topics:    make(map[whisper.TopicType]int),
+		done:      make(chan struct{}),
+	}
+}
+
+// Expect increases the number of requests which have to finish before Done is closed.
+func (t *requestTracker) Expect(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expected += n
+}
+
+// SentAll marks that no more requests are expected.
+func (t *requestTracker) SentAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sentAll = true
+	t.checkDone()
+}
+
+// Done is closed when all expected requests have finished.
+func (t *requestTracker) Done() <-chan struct{} {
+	return t.done
+}
+
+// Sent starts tracking a request.
+func (t *requestTracker) Sent(hash string, sentAt time.Time, p requestParams) *trackedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := newTrackedRequest(hash, sentAt, p)
+	if resp, ok := t.matcher.Sent(hash); ok {
+		t.finish(r, resp)
+		return r
+	}
+	t.pending[hash] = r
+	return r
+}
+
+// SendFailed records a request which could not be sent.
+func (t *requestTracker) SendFailed(sentAt time.Time, p requestParams, err error) *trackedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := newTrackedRequest("", sentAt, p)
+	t.finish(r, mailserver.Response{Outcome: outcomeError, Error: err.Error(), ReceivedAt: time.Now()})
+	return r
+}
+
+// HandleResponse matches a mail server response with a pending request.
+func (t *requestTracker) HandleResponse(resp mailserver.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch t.matcher.Match(resp) {
+	case mailserver.Matched:
+		r := t.pending[resp.RequestHash]
+		delete(t.pending, resp.RequestHash)
+		t.finish(r, resp)
+	case mailserver.Duplicate:
+		log.Printf("ignored a duplicate %s response for request %s", resp.Outcome, resp.RequestHash)
+		unmatchedSignalsCounter.Inc()
+		t.unmatched++
+	}
+}
+
+// Run times out requests and unmatched signals until all expected requests finish.
+func (t *requestTracker) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			t.expire(now)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *requestTracker) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for hash, r := range t.pending {
+		if now.Sub(r.SentAt) >= t.timeout {
+			delete(t.pending, hash)
+			t.matcher.Finish(hash)
+			t.finish(r, mailserver.Response{Outcome: outcomeTimeout, ReceivedAt: now})
+		}
+	}
+	for _, resp := range t.matcher.ExpireEarly(now.Add(-t.timeout)) {
+		log.Printf("signal for unknown request %s", resp.RequestHash)
+		unmatchedSignalsCounter.Inc()
+		t.unmatched++
+	}
+}
+
+// finish must be called with the lock held.
+func (t *requestTracker) finish(r *trackedRequest, resp mailserver.Response) {
+	r.Outcome = resp.Outcome
+	r.FinishedAt = resp.ReceivedAt
+	r.Cursor = resp.Cursor
+	r.LastEnvelopeHash = resp.LastEnvelopeHash
+	r.Error = resp.Error
+	close(r.done)
+
+	t.finished = append(t.finished, r)
+
+	requestsCounter.WithLabelValues(mailServerLabel(r.MailServer), r.Outcome).Inc()
+	switch {
+	case r.Outcome == outcomeCompleted:
+		requestDurationHistogram.WithLabelValues(mailServerLabel(r.MailServer)).Observe(r.Latency().Seconds())
+		log.Printf("request %s completed in %s with %d envelopes (%d bytes)", r.Hash, round(r.Latency()), r.Envelopes, r.Bytes)
+	case r.Error != "":
+		log.Printf("request %s %s: %s", r.Hash, r.Outcome, r.Error)
+	default:
+		log.Printf("request %s %s", r.Hash, r.Outcome)
+	}
+
+	t.checkDone()
+}
+
+// checkDone must be called with the lock held.
+func (t *requestTracker) checkDone() {
+	if !t.closed && t.sentAll && len(t.finished) >= t.expected {
+		t.closed = true
+		close(t.done)
+	}
+}
+
+// Finished returns finished requests ordered by the time they were sent.
+func (t *requestTracker) Finished() []*trackedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := append([]*trackedRequest(nil), t.finished...)
+	sort.Slice(result, func(i, j int) bool { return result[i].SentAt.Before(result[j].SentAt) })
+	return result
+}
+
+// Unmatched returns the number of signals which did not match any request,
+// including the ones still waiting for their request.
+func (t *requestTracker) Unmatched() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.unmatched + t.matcher.Early()
+}
+
+// countOutcomes returns the number of requests per outcome.
+func countOutcomes(requests []*trackedRequest) map[string]int {
+	counts := make(map[string]int)
+	for _, o := range outcomes {
+		counts[o] = 0
+	}
+	for _, r := range requests {
+		counts[r.Outcome]++
+	}
+	return counts
+}
+
+// completedLatencies returns latencies of completed requests.
+func completedLatencies(requests []*trackedRequest) []time.Duration {
+	var result []time.Duration
+	for _, r := range requests {
+		if r.Outcome == outcomeCompleted {
+			result = append(result, r.Latency())
+		}
+	}
+	return result
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/status-im/statusd-bots/mailserver"
+)
+
+func TestRequestTracker(t *testing.T) {
+	const timeout = 10 * time.Second
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type step struct {
+		op      string // "sent", "signal", "expire" or "sentAll"
+		hash    string
+		outcome string // of a signal
+		at      time.Duration
+	}
+
+	for _, tc := range []struct {
+		name      string
+		expect    int
+		steps     []step
+		want      map[string]string // request hash => outcome
+		unmatched int
+		done      bool
+	}{
+		{
+			name:   "signal after the request",
+			expect: 1,
+			steps: []step{
+				{op: "sent", hash: "aa"},
+				{op: "signal", hash: "aa", outcome: outcomeCompleted, at: time.Second},
+				{op: "sentAll"},
+			},
+			want: map[string]string{"aa": outcomeCompleted},
+			done: true,
+		},
+		{
+			name:   "early signal",
+			expect: 1,
+			steps: []step{
+				{op: "signal", hash: "aa", outcome: outcomeFailed},
+				{op: "sent", hash: "aa", at: time.Second},
+				{op: "sentAll"},
+			},
+			want: map[string]string{"aa": outcomeFailed},
+			done: true,
+		},
+		{
+			name:   "duplicate signal",
+			expect: 1,
+			steps: []step{
+				{op: "sent", hash: "aa"},
+				{op: "signal", hash: "aa", outcome: outcomeCompleted, at: time.Second},
+				{op: "signal", hash: "aa", outcome: outcomeExpired, at: 2 * time.Second},
+				{op: "sentAll"},
+			},
+			want:      map[string]string{"aa": outcomeCompleted},
+			unmatched: 1,
+			done:      true,
+		},
+		{
+			name:   "timeout",
+			expect: 1,
+			steps: []step{
+				{op: "sent", hash: "aa"},
+				{op: "sentAll"},
+				{op: "expire", at: timeout - time.Second},
+				{op: "expire", at: timeout},
+			},
+			want: map[string]string{"aa": outcomeTimeout},
+			done: true,
+		},
+		{
+			name:   "signal after a timeout",
+			expect: 1,
+			steps: []step{
+				{op: "sent", hash: "aa"},
+				{op: "expire", at: timeout},
+				{op: "signal", hash: "aa", outcome: outcomeCompleted, at: timeout + time.Second},
+				{op: "sentAll"},
+			},
+			want:      map[string]string{"aa": outcomeTimeout},
+			unmatched: 1,
+			done:      true,
+		},
+		{
+			name:   "early signal of an unknown request expires",
+			expect: 0,
+			steps: []step{
+				{op: "signal", hash: "bb", outcome: outcomeCompleted},
+				{op: "expire", at: timeout},
+				{op: "sentAll"},
+			},
+			want:      map[string]string{},
+			unmatched: 1,
+			done:      true,
+		},
+		{
+			name:   "not done until sent all",
+			expect: 1,
+			steps: []step{
+				{op: "sent", hash: "aa"},
+				{op: "signal", hash: "aa", outcome: outcomeCompleted, at: time.Second},
+			},
+			want: map[string]string{"aa": outcomeCompleted},
+		},
+		{
+			name:   "not done while requests are expected",
+			expect: 2,
+			steps: []step{
+				{op: "sent", hash: "aa"},
+				{op: "signal", hash: "aa", outcome: outcomeCompleted, at: time.Second},
+				{op: "sentAll"},
+			},
+			want: map[string]string{"aa": outcomeCompleted},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newRequestTracker(timeout)
+			tracker.Expect(tc.expect)
+
+			for _, s := range tc.steps {
+				at := start.Add(s.at)
+				switch s.op {
+				case "sent":
+					tracker.Sent(s.hash, at, requestParams{})
+				case "signal":
+					tracker.HandleResponse(mailserver.Response{RequestHash: s.hash, Outcome: s.outcome, ReceivedAt: at})
+				case "expire":
+					tracker.expire(at)
+				case "sentAll":
+					tracker.SentAll()
+				}
+			}
+
+			got := make(map[string]string)
+			for _, r := range tracker.Finished() {
+				got[r.Hash] = r.Outcome
+			}
+			if len(got) != len(tc.want) {
+				t.Errorf("finished requests = %v, want %v", got, tc.want)
+			}
+			for hash, outcome := range tc.want {
+				if got[hash] != outcome {
+					t.Errorf("request %s outcome = %q, want %q", hash, got[hash], outcome)
+				}
+			}
+			if got := tracker.Unmatched(); got != tc.unmatched {
+				t.Errorf("unmatched signals = %d, want %d", got, tc.unmatched)
+			}
+
+			select {
+			case <-tracker.Done():
+				if !tc.done {
+					t.Error("tracker is done")
+				}
+			default:
+				if tc.done {
+					t.Error("tracker is not done")
+				}
+			}
+		})
+	}
+}
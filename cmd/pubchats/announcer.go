@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"text/template"
+	"time"
+)
+
+// announcement is a message posted to public chats according to a schedule.
+// Exactly one of Interval and Cron must be set.
+type announcement struct {
+	Name     string   `json:"name"`
+	Channels []string `json:"channels"`
+	Interval string   `json:"interval"` // for example "1h30m"
+	Cron     string   `json:"cron"`     // for example "0 9 * * 1-5"
+	Template string   `json:"template"` // text/template with announcementData
+
+	schedule schedule
+	tmpl     *template.Template
+}
+
+// announcementData is available in announcement templates.
+type announcementData struct {
+	Name    string
+	Channel string
+	Time    time.Time
+	Count   int // number of times the announcement has been posted since start
+}
+
+func loadAnnouncements(path string) ([]*announcement, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var announcements []*announcement
+	if err := json.Unmarshal(data, &announcements); err != nil {
+		return nil, err
+	}
+
+	for i, a := range announcements {
+		if a.Name == "" {
+			a.Name = fmt.Sprintf("announcement-%d", i)
+		}
+		if err := a.init(); err != nil {
+			return nil, fmt.Errorf("invalid announcement '%s': %v", a.Name, err)
+		}
+	}
+
+	return announcements, nil
+}
+
+func (a *announcement) init() error {
+	if len(a.Channels) == 0 {
+		return errors.New("no channels")
+	}
+
+	switch {
+	case a.Interval != "" && a.Cron != "":
+		return errors.New("interval and cron are mutually exclusive")
+	case a.Interval != "":
+		d, err := time.ParseDuration(a.Interval)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		a.schedule = intervalSchedule(d)
+	case a.Cron != "":
+		s, err := parseCron(a.Cron)
+		if err != nil {
+			return err
+		}
+		a.schedule = s
+	default:
+		return errors.New("interval or cron is required")
+	}
+
+	tmpl, err := template.New(a.Name).Parse(a.Template)
+	if err != nil {
+		return err
+	}
+	a.tmpl = tmpl
+
+	return nil
+}
+
+func (a *announcement) render(data announcementData) (string, error) {
+	var buf bytes.Buffer
+	if err := a.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// runAnnouncement posts the announcement on its schedule until done is closed.
+func runAnnouncement(a *announcement, p *publisher, done <-chan struct{}) {
+	for count := 0; ; count++ {
+		now := time.Now()
+		next := a.schedule.Next(now)
+		if next.IsZero() {
+			log.Printf("announcement '%s' will never be posted again", a.Name)
+			return
+		}
+
+		select {
+		case <-time.After(next.Sub(now)):
+		case <-done:
+			return
+		}
+
+		for _, channel := range a.Channels {
+			text, err := a.render(announcementData{
+				Name:    a.Name,
+				Channel: channel,
+				Time:    next,
+				Count:   count,
+			})
+			if err != nil {
+				log.Printf("failed to render announcement '%s': %v", a.Name, err)
+				continue
+			}
+
+			if err := p.Publish(channel, text); err != nil {
+				log.Printf("failed to post announcement '%s' to channel '%s': %v", a.Name, channel, err)
+				continue
+			}
+			log.Printf("posted announcement '%s' to channel '%s'", a.Name, channel)
+		}
+	}
+}
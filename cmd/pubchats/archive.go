@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	archiveDayLayout     = "2006-01-02"
+	archiveSegmentSuffix = ".jsonl"
+)
+
+// messageArchive stores received messages as JSON lines.
+// Every channel of a fleet has a directory with one segment file per day (UTC):
+// <dir>/<fleet>/<channel>/<YYYY-MM-DD>.jsonl
+// Fleets have their own segments, as a channel tracked in several fleets
+// receives the same messages from each of them.
+type messageArchive struct {
+	dir string
+
+	mu       sync.Mutex
+	segments map[channelKey]*archiveSegment // currently written segments
+
+	compactMu sync.Mutex // serializes compactions, which mostly run without mu
+}
+
+type archiveSegment struct {
+	day  string
+	file *os.File
+	enc  *json.Encoder
+}
+
+func newMessageArchive(dir string) (*messageArchive, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &messageArchive{
+		dir:      dir,
+		segments: make(map[channelKey]*archiveSegment),
+	}, nil
+}
+
+func (a *messageArchive) HandleMessage(m *receivedMessage) {
+	if err := a.append(m); err != nil {
+		log.Printf("failed to archive a message from channel '%s' of fleet %s: %v", m.Chat, m.Fleet, err)
+	}
+}
+
+func (a *messageArchive) append(m *receivedMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	day := m.ReceivedAt.UTC().Format(archiveDayLayout)
+
+	s, ok := a.segments[m.channel()]
+	if !ok || s.day != day {
+		if ok {
+			_ = s.file.Close()
+		}
+
+		dir := archiveChannelDir(a.dir, m.channel())
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(dir, day+archiveSegmentSuffix), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+		if err != nil {
+			return err
+		}
+		s = &archiveSegment{day: day, file: f, enc: json.NewEncoder(f)}
+		a.segments[m.channel()] = s
+	}
+
+	return s.enc.Encode(m)
+}
+
+// Close closes all open segments.
+func (a *messageArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var firstErr error
+	for ch, s := range a.segments {
+		if err := s.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(a.segments, ch)
+	}
+	return firstErr
+}
+
+func archiveChannelDir(dir string, ch channelKey) string {
+	return filepath.Join(dir, url.PathEscape(ch.Fleet), url.PathEscape(ch.Chat))
+}
+
+// archiveChannels returns channels of all fleets found in the archive.
+func archiveChannels(dir string) ([]channelKey, error) {
+	fleets, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []channelKey
+	for _, f := range fleets {
+		fleet, err := url.PathUnescape(f.Name())
+		if !f.IsDir() || err != nil {
+			continue
+		}
+		chats, err := ioutil.ReadDir(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chats {
+			chat, err := url.PathUnescape(c.Name())
+			if !c.IsDir() || err != nil {
+				continue
+			}
+			channels = append(channels, channelKey{Fleet: fleet, Chat: chat})
+		}
+	}
+	return channels, nil
+}
+
+// readMessages calls fn for every message stored in path, which is
+// either an archive directory, including an export directory with gzipped
+// segments, or a single JSONL file, optionally gzipped. Messages from
+// an archive are ordered by the receive time, messages from a file
+// are passed in the order they were written.
+func readMessages(path string, fn func(*receivedMessage) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return readSegment(path, fn)
+	}
+
+	days, err := archiveSegmentsByDay(path)
+	if err != nil {
+		return err
+	}
+
+	dayNames := make([]string, 0, len(days))
+	for day := range days {
+		dayNames = append(dayNames, day)
+	}
+	sort.Strings(dayNames)
+
+	// Segments are merged day by day to keep the memory usage
+	// proportional to the traffic of a single day.
+	for _, day := range dayNames {
+		var messages []*receivedMessage
+		for _, segment := range days[day] {
+			err := readSegment(segment, func(m *receivedMessage) error {
+				messages = append(messages, m)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		sort.SliceStable(messages, func(i, j int) bool {
+			return messages[i].ReceivedAt.Before(messages[j].ReceivedAt)
+		})
+
+		for _, m := range messages {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// archiveSegmentsByDay returns paths of segment files of all fleets grouped by day.
+func archiveSegmentsByDay(dir string) (map[string][]string, error) {
+	channels, err := archiveChannels(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make(map[string][]string)
+	for _, ch := range channels {
+		chDir := archiveChannelDir(dir, ch)
+		segments, err := ioutil.ReadDir(chDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range segments {
+			day, ok := archiveSegmentDay(s)
+			if !ok {
+				continue
+			}
+			days[day] = append(days[day], filepath.Join(chDir, s.Name()))
+		}
+	}
+
+	return days, nil
+}
+
+// archiveSegmentDay returns the day of a segment or an exported segment file.
+func archiveSegmentDay(f os.FileInfo) (string, bool) {
+	switch {
+	case f.IsDir():
+		return "", false
+	case strings.HasSuffix(f.Name(), archiveSegmentSuffix):
+		return strings.TrimSuffix(f.Name(), archiveSegmentSuffix), true
+	case strings.HasSuffix(f.Name(), archiveExportSuffix):
+		return strings.TrimSuffix(f.Name(), archiveExportSuffix), true
+	}
+	return "", false
+}
+
+func readSegment(path string, fn func(*receivedMessage) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return decodeMessages(zr, path, fn)
+	}
+
+	return decodeMessages(f, path, fn)
+}
+
+func decodeMessages(r io.Reader, source string, fn func(*receivedMessage) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var m receivedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return fmt.Errorf("%s:%d: %v", source, line, err)
+		}
+		if err := fn(&m); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
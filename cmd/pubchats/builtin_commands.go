@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var startTime = time.Now()
+
+// registerBuiltinCommands adds /help, /stats and /uptime.
+// Only channels tracked in the fleet can be queried with /stats.
+func registerBuiltinCommands(d *commandDispatcher, fleet string, trackedChannels []string) {
+	d.Register(&command{
+		Name:  "help",
+		Usage: "/help",
+		Help:  "list available commands",
+		Handler: func(req commandRequest) (string, error) {
+			lines := []string{"Available commands:"}
+			for _, c := range d.Commands() {
+				lines = append(lines, fmt.Sprintf("%s - %s", c.Usage, c.Help))
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+
+	d.Register(&command{
+		Name:  "stats",
+		Usage: "/stats [channel...]",
+		Help:  "show message counts since the bot started",
+		Handler: func(req commandRequest) (string, error) {
+			channels := req.Args
+			if len(channels) == 0 {
+				channels = []string{req.Chat}
+			}
+
+			var lines []string
+			for _, ch := range channels {
+				ch = strings.TrimPrefix(ch, "#")
+				if !containsString(trackedChannels, ch) {
+					lines = append(lines, fmt.Sprintf("#%s: not tracked", ch))
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("#%s: %.0f messages, %.0f unique authors",
+					ch, messagesCounter.Value(fleet, ch), uniqueCounter.Value(fleet, ch)))
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+
+	d.Register(&command{
+		Name:  "uptime",
+		Usage: "/uptime",
+		Help:  "show how long the bot has been running",
+		Handler: func(req commandRequest) (string, error) {
+			return fmt.Sprintf("up for %s", time.Since(startTime).Round(time.Second)), nil
+		},
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/status-im/status-go/node"
+	"github.com/status-im/status-go/params"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+const (
+	censusUnknownChat = "unknown"
+	censusOtherTopic  = "other"
+)
+
+// runCensus accepts all envelopes the node receives, not only the ones
+// from tracked channels, and counts them per topic. Topics are matched
+// against a dictionary of candidate public chat names.
+func runCensus() {
+	fleet := mustParseSingleFleet("census")
+
+	config, err := newNodeConfig(fleet.Name, params.MainNetworkID)
+	if err != nil {
+		log.Fatalf("failed to create a config: %v", err)
+	}
+	log.Printf("using config: %v", config)
+
+	names := append([]string{}, fleet.Channels...)
+	if *censusDictionary != "" {
+		dictionary, err := readDictionary(*censusDictionary)
+		if err != nil {
+			log.Fatalf("failed to read dictionary: %v", err)
+		}
+		names = append(names, dictionary...)
+	}
+
+	topicsToNamesMap, err := topicsToNames(names)
+	if err != nil {
+		log.Fatalf("failed to get topics to names mapping: %v", err)
+	}
+	log.Printf("matching topics against %d names", len(topicsToNamesMap))
+
+	n := node.New()
+	if err := n.Start(config); err != nil {
+		log.Fatalf("failed to start a node: %v", err)
+	}
+
+	shh, err := n.WhisperService()
+	if err != nil {
+		log.Fatalf("failed to get a whisper service: %v", err)
+	}
+
+	// Ask peers to send all envelopes instead of the ones
+	// matching topics of the installed filters.
+	if err := shh.SetBloomFilter(whisper.MakeFullNodeBloom()); err != nil {
+		log.Fatalf("failed to set bloom filter: %v", err)
+	}
+
+	census := newTopicCensus(fleet.Name, topicsToNamesMap, *censusMaxTopics)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sinks := startMetricsSinks()
+
+	ticker := time.NewTicker(*censusInterval)
+	defer ticker.Stop()
+
+	log.Println("counting envelopes...")
+
+	for {
+		select {
+		case now := <-ticker.C:
+			// Envelopes stay in the pool until they expire,
+			// so polling more often than their TTL sees all of them.
+			census.Add(shh.Envelopes(), now)
+		case <-signals:
+			closeMetricsSinks(sinks)
+			os.Exit(1)
+		}
+	}
+}
+
+// topicCensus counts envelopes and bytes per topic. Topics not matched
+// with any name are exported by their hex value, but only up to maxTopics
+// of them; the rest are counted as "other".
+type topicCensus struct {
+	fleet     string
+	names     map[whisper.TopicType]string
+	maxTopics int
+
+	unmatched map[whisper.TopicType]struct{} // topics with their own label
+	seen      map[common.Hash]uint32         // envelope hash => expiry
+}
+
+func newTopicCensus(fleet string, names map[whisper.TopicType]string, maxTopics int) *topicCensus {
+	return &topicCensus{
+		fleet:     fleet,
+		names:     names,
+		maxTopics: maxTopics,
+		unmatched: make(map[whisper.TopicType]struct{}),
+		seen:      make(map[common.Hash]uint32),
+	}
+}
+
+// Add counts envelopes which have not been counted yet.
+func (c *topicCensus) Add(envelopes []*whisper.Envelope, now time.Time) {
+	for _, e := range envelopes {
+		hash := e.Hash()
+		if _, ok := c.seen[hash]; ok {
+			continue
+		}
+		c.seen[hash] = e.Expiry
+
+		topic, chat := c.labels(e.Topic)
+		censusEnvelopesCounter.WithLabelValues(c.fleet, topic, chat).Inc()
+		censusBytesCounter.WithLabelValues(c.fleet, topic, chat).Add(float64(whisper.EnvelopeHeaderLength + len(e.Data)))
+	}
+
+	// Expired envelopes are removed from the pool, so they can't be seen again.
+	for hash, expiry := range c.seen {
+		if int64(expiry) < now.Unix() {
+			delete(c.seen, hash)
+		}
+	}
+}
+
+func (c *topicCensus) labels(t whisper.TopicType) (topic, chat string) {
+	if name, ok := c.names[t]; ok {
+		return t.String(), name
+	}
+
+	if _, ok := c.unmatched[t]; !ok {
+		if len(c.unmatched) >= c.maxTopics {
+			return censusOtherTopic, censusUnknownChat
+		}
+		c.unmatched[t] = struct{}{}
+		log.Printf("found an unknown topic %s", t)
+	}
+
+	return t.String(), censusUnknownChat
+}
+
+// readDictionary reads chat names, one per line. Empty lines
+// and lines starting with "#" are skipped.
+func readDictionary(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
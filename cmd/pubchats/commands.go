@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const commandPrefix = "/"
+
+// commandRequest is a parsed command received in a public chat.
+type commandRequest struct {
+	Chat   string
+	Author string
+	Name   string
+	Args   []string
+}
+
+// commandHandler returns a reply that is posted to the chat the command came from.
+type commandHandler func(req commandRequest) (string, error)
+
+type command struct {
+	Name    string
+	Usage   string
+	Help    string
+	Handler commandHandler
+}
+
+// commandDispatcher recognizes commands in messages from the configured channels
+// and dispatches them to registered handlers.
+type commandDispatcher struct {
+	publisher *publisher
+	limiter   *rateLimiter
+	channels  map[string]struct{}
+	commands  map[string]*command
+	botID     string // messages from the bot itself are ignored
+}
+
+func newCommandDispatcher(p *publisher, limiter *rateLimiter, channels []string, botID string) *commandDispatcher {
+	d := &commandDispatcher{
+		publisher: p,
+		limiter:   limiter,
+		channels:  make(map[string]struct{}),
+		commands:  make(map[string]*command),
+		botID:     botID,
+	}
+	for _, ch := range channels {
+		d.channels[ch] = struct{}{}
+	}
+	return d
+}
+
+// Register adds a command. A command registered later replaces
+// the previous one with the same name.
+func (d *commandDispatcher) Register(c *command) {
+	d.commands[c.Name] = c
+}
+
+// Commands returns registered commands sorted by name.
+func (d *commandDispatcher) Commands() []*command {
+	result := make([]*command, 0, len(d.commands))
+	for _, c := range d.commands {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func (d *commandDispatcher) HandleMessage(m *receivedMessage) {
+	d.Dispatch(m.Chat, m.Author, m.Decoded().Text)
+}
+
+// Dispatch handles a message if it is a command. Replies are posted asynchronously.
+func (d *commandDispatcher) Dispatch(chat, author, text string) {
+	if _, ok := d.channels[chat]; !ok || author == d.botID {
+		return
+	}
+	if !strings.HasPrefix(text, commandPrefix) {
+		return
+	}
+
+	args, err := parseCommandArgs(strings.TrimPrefix(text, commandPrefix))
+	if err != nil || len(args) == 0 {
+		return
+	}
+
+	req := commandRequest{
+		Chat:   chat,
+		Author: author,
+		Name:   strings.ToLower(args[0]),
+		Args:   args[1:],
+	}
+
+	c, ok := d.commands[req.Name]
+	if !ok {
+		return
+	}
+
+	if !d.limiter.Allow(req.Author, req.Name, time.Now()) {
+		log.Printf("rate limited command /%s from %s in channel '%s'", req.Name, req.Author, req.Chat)
+		return
+	}
+
+	go d.handle(c, req)
+}
+
+func (d *commandDispatcher) handle(c *command, req commandRequest) {
+	reply, err := c.Handler(req)
+	if err != nil {
+		reply = fmt.Sprintf("/%s failed: %v", c.Name, err)
+	}
+	if reply == "" {
+		return
+	}
+
+	if err := d.publisher.Publish(req.Chat, reply); err != nil {
+		log.Printf("failed to reply to /%s in channel '%s': %v", c.Name, req.Chat, err)
+	}
+}
+
+// parseCommandArgs splits a command line into arguments.
+// Arguments are separated by white spaces, unless enclosed in double quotes.
+func parseCommandArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		quoted  bool
+		started bool
+	)
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case unicode.IsSpace(r) && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if started {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandArgs(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "   ", want: nil},
+		{line: "stats", want: []string{"stats"}},
+		{line: "  top  10 ", want: []string{"top", "10"}},
+		{line: "search\tfoo\nbar", want: []string{"search", "foo", "bar"}},
+		{line: `search "hello world"`, want: []string{"search", "hello world"}},
+		{line: `search "  padded  "`, want: []string{"search", "  padded  "}},
+		{line: `search ""`, want: []string{"search", ""}},
+		{line: `search foo"bar baz"`, want: []string{"search", "foobar baz"}},
+		{line: `search "a""b"`, want: []string{"search", "ab"}},
+		{line: `search "héllo wörld"`, want: []string{"search", "héllo wörld"}},
+		{line: `search "unterminated`, wantErr: true},
+		{line: `search "a" "`, wantErr: true},
+	} {
+		got, err := parseCommandArgs(tc.line)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseCommandArgs(%q) error = %v, want error %v", tc.line, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseCommandArgs(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
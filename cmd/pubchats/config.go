@@ -23,3 +23,28 @@ func newNodeConfig(fleet string, networkID uint64) (*params.NodeConfig, error) {
 
 	return c, nil
 }
+
+// newVantageNodeConfig creates a config of a node which is pinned
+// to a single fleet node through static peers. Discovery is disabled
+// so that the node receives envelopes only from that peer.
+func newVantageNodeConfig(fleet, peer, dataDir string, networkID uint64) (*params.NodeConfig, error) {
+	c, err := params.NewNodeConfigWithDefaults(
+		dataDir, networkID, params.WithFleet(fleet))
+	if err != nil {
+		return nil, err
+	}
+
+	c.ListenAddr = ":0"
+	c.MaxPeers = 1
+	c.IPCEnabled = false
+	c.HTTPEnabled = false
+	c.NoDiscovery = true
+	c.Rendezvous = false
+	c.RequireTopics = nil
+
+	c.ClusterConfig.StaticNodes = []string{peer}
+	c.ClusterConfig.BootNodes = nil
+	c.ClusterConfig.RendezvousNodes = nil
+
+	return c, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/status-im/status-go/node"
+	"github.com/status-im/status-go/params"
+	"github.com/status-im/whisper/shhclient"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// vantage is an embedded node pinned to a single fleet node.
+type vantage struct {
+	Name  string
+	Enode string
+
+	node *node.StatusNode
+	shh  *shhclient.Client
+}
+
+type vantageMessage struct {
+	vantage    int
+	msg        *whisper.Message
+	receivedAt time.Time
+}
+
+// runCoverage starts a node for each fleet node and measures
+// which of them delivered envelopes from the tracked channels and when.
+func runCoverage() {
+	fleet := mustParseSingleFleet("coverage")
+
+	config, err := newNodeConfig(fleet.Name, params.MainNetworkID)
+	if err != nil {
+		log.Fatalf("failed to create a config: %v", err)
+	}
+
+	peers := *vantagePeers
+	if len(peers) == 0 {
+		peers = config.ClusterConfig.StaticNodes
+	}
+	if len(peers) == 0 {
+		log.Fatalf("no fleet nodes to connect to")
+	}
+
+	topicsToNamesMap, err := topicsToNames(fleet.Channels)
+	if err != nil {
+		log.Fatalf("failed to get topics to names mapping: %v", err)
+	}
+
+	var coverageLog *os.File
+	if *coverageLogPath != "" {
+		coverageLog, err = os.OpenFile(*coverageLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+		if err != nil {
+			log.Fatalf("failed to open coverage log: %v", err)
+		}
+		defer coverageLog.Close()
+	}
+
+	done := make(chan struct{})
+	messages := make(chan vantageMessage)
+	vantages := make([]*vantage, len(peers))
+
+	for i, peer := range peers {
+		v, err := startVantage(fleet.Name, peer, i)
+		if err != nil {
+			log.Fatalf("failed to start a node pinned to %s: %v", peer, err)
+		}
+		vantages[i] = v
+		log.Printf("started vantage point %s", v.Name)
+
+		for _, name := range fleet.Channels {
+			v.subscribe(fleet.Name, name, i, messages, done)
+		}
+	}
+
+	tracker := newCoverageTracker(fleet.Name, vantages, *coverageWindow, coverageLog)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sinks := startMetricsSinks()
+
+	sweep := time.NewTicker(*coverageWindow / 4)
+	defer sweep.Stop()
+
+	log.Println("waiting for messages...")
+
+	for {
+		select {
+		case m := <-messages:
+			expiry := time.Unix(int64(m.msg.Timestamp)+int64(m.msg.TTL), 0)
+			tracker.Add(m.vantage, topicsToNamesMap[m.msg.Topic], hex.EncodeToString(m.msg.Hash), expiry, m.receivedAt)
+		case now := <-sweep.C:
+			tracker.Sweep(now)
+		case <-signals:
+			close(done)
+			for _, v := range vantages {
+				if err := v.node.Stop(); err != nil {
+					log.Printf("failed to stop node %s: %v", v.Name, err)
+				}
+			}
+			closeMetricsSinks(sinks)
+			os.Exit(1)
+		}
+	}
+}
+
+func startVantage(fleet, peer string, index int) (*vantage, error) {
+	name, err := vantageName(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir := filepath.Join(os.TempDir(), "pubchats", fmt.Sprintf("vantage-%d", index))
+	if *datadir != "" {
+		dataDir = filepath.Join(*datadir, fmt.Sprintf("vantage-%d", index))
+	}
+
+	config, err := newVantageNodeConfig(fleet, peer, dataDir, params.MainNetworkID)
+	if err != nil {
+		return nil, err
+	}
+
+	n := node.New()
+	if err := n.Start(config); err != nil {
+		return nil, err
+	}
+
+	rpcClient, err := n.GethNode().Attach()
+	if err != nil {
+		return nil, err
+	}
+
+	return &vantage{
+		Name:  name,
+		Enode: peer,
+		node:  n,
+		shh:   shhclient.NewClient(rpcClient),
+	}, nil
+}
+
+// subscribe forwards messages from the channel tagged with the vantage index
+// until done is closed. The subscription is supervised like the ones of
+// the fleet monitor, and its metrics are labeled with the vantage point.
+func (v *vantage) subscribe(fleet, chat string, index int, out chan<- vantageMessage, done <-chan struct{}) {
+	messages := make(chan *whisper.Message)
+	go superviseChannel(v.shh, fleet, v.Name, chat, messages, done)
+
+	go func() {
+		for {
+			select {
+			case msg := <-messages:
+				out <- vantageMessage{vantage: index, msg: msg, receivedAt: time.Now()}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// vantageName returns a short name of a fleet node used as a metric label.
+func vantageName(peer string) (string, error) {
+	n, err := enode.ParseV4(peer)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", n.IP(), n.TCP()), nil
+}
+
+// coverageTracker records which vantage points received each envelope.
+// An envelope is finalized after the window passes since it was first seen,
+// and vantage points which have not received it by then count it as missed.
+// Finalized envelopes are kept until they expire, so envelopes received
+// after the window are counted as late instead of as new envelopes.
+type coverageTracker struct {
+	fleet    string
+	vantages []*vantage
+	window   time.Duration
+	log      *json.Encoder
+
+	envelopes map[string]*envelopeSightings // hash => sightings
+}
+
+type envelopeSightings struct {
+	chat      string
+	first     time.Time
+	seenAt    []time.Time // by vantage index, zero if not seen
+	finalized bool
+	expires   time.Time // when the finalized envelope is forgotten
+}
+
+// coverageRecord is written to the coverage log for every finalized envelope.
+type coverageRecord struct {
+	Hash   string               `json:"hash"`
+	Chat   string               `json:"chat"`
+	First  time.Time            `json:"first"`
+	SeenBy map[string]time.Time `json:"seenBy"`
+	Missed []string             `json:"missed,omitempty"`
+}
+
+func newCoverageTracker(fleet string, vantages []*vantage, window time.Duration, logFile *os.File) *coverageTracker {
+	t := &coverageTracker{
+		fleet:     fleet,
+		vantages:  vantages,
+		window:    window,
+		envelopes: make(map[string]*envelopeSightings),
+	}
+	if logFile != nil {
+		t.log = json.NewEncoder(logFile)
+	}
+	return t
+}
+
+// Add records that the vantage point received the envelope which expires at expiry.
+func (t *coverageTracker) Add(vantage int, chat, hash string, expiry, at time.Time) {
+	s, ok := t.envelopes[hash]
+	if !ok {
+		// Nodes drop expired envelopes, but their clocks may differ by a window.
+		// An envelope received after its expiry is kept until it is finalized.
+		expires := expiry
+		if at.After(expires) {
+			expires = at
+		}
+		s = &envelopeSightings{
+			chat:    chat,
+			first:   at,
+			seenAt:  make([]time.Time, len(t.vantages)),
+			expires: expires.Add(t.window),
+		}
+		t.envelopes[hash] = s
+	}
+
+	if !s.seenAt[vantage].IsZero() {
+		return
+	}
+	s.seenAt[vantage] = at
+
+	name := t.vantages[vantage].Name
+	if s.finalized {
+		coverageLateCounter.WithLabelValues(t.fleet, name).Inc()
+		return
+	}
+	coverageEnvelopesCounter.WithLabelValues(t.fleet, name).Inc()
+	coverageDelayHistogram.WithLabelValues(t.fleet, name).Observe(at.Sub(s.first).Seconds())
+}
+
+// Sweep finalizes envelopes first seen before now minus the window
+// and forgets expired ones.
+func (t *coverageTracker) Sweep(now time.Time) {
+	for hash, s := range t.envelopes {
+		if s.finalized {
+			if now.After(s.expires) {
+				delete(t.envelopes, hash)
+			}
+			continue
+		}
+		if now.Sub(s.first) < t.window {
+			continue
+		}
+
+		record := coverageRecord{
+			Hash:   hash,
+			Chat:   s.chat,
+			First:  s.first,
+			SeenBy: make(map[string]time.Time),
+		}
+		for i, at := range s.seenAt {
+			name := t.vantages[i].Name
+			if at.IsZero() {
+				coverageMissedCounter.WithLabelValues(t.fleet, name).Inc()
+				record.Missed = append(record.Missed, name)
+			} else {
+				record.SeenBy[name] = at
+			}
+		}
+		coverageObservedCounter.WithLabelValues(t.fleet).Inc()
+
+		if t.log != nil {
+			if err := t.log.Encode(record); err != nil {
+				log.Printf("failed to write coverage record: %v", err)
+			}
+		}
+
+		s.finalized = true
+	}
+}
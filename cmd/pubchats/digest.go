@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	digestTopAuthors = 10
+	// An hour is reported as a drop if its volume falls below
+	// this fraction of the average of the preceding hours.
+	digestDropRatio = 0.25
+	// Drops are not reported for channels with less traffic than this per hour.
+	digestDropMinAverage = 5
+	digestDropWindow     = 3 // hours
+	// Authors not seen for this long are forgotten and reported as new again.
+	digestAuthorMemory = 90 * 24 * time.Hour
+)
+
+// digestCollector aggregates messages for periodic channel reports.
+// Channels are reported per fleet, as a channel tracked in several fleets
+// receives the same messages from each of them.
+type digestCollector struct {
+	stateFile string
+
+	mu       sync.Mutex
+	start    time.Time
+	channels map[channelKey]*channelDigest
+	state    digestState
+}
+
+// digestState is preserved between periods and restarts.
+type digestState struct {
+	Channels map[string]map[string]*channelState `json:"channels"` // fleet => chat => state
+}
+
+type channelState struct {
+	KnownAuthors  map[string]time.Time `json:"knownAuthors"` // author => last seen
+	PreviousTotal int                  `json:"previousTotal"`
+}
+
+// channel returns the state of the channel, which is created if needed.
+func (s *digestState) channel(ch channelKey) *channelState {
+	if s.Channels == nil {
+		s.Channels = make(map[string]map[string]*channelState)
+	}
+	chats, ok := s.Channels[ch.Fleet]
+	if !ok {
+		chats = make(map[string]*channelState)
+		s.Channels[ch.Fleet] = chats
+	}
+	state, ok := chats[ch.Chat]
+	if !ok {
+		state = &channelState{KnownAuthors: make(map[string]time.Time)}
+		chats[ch.Chat] = state
+	}
+	return state
+}
+
+type channelDigest struct {
+	hourly  map[int64]int // hour as unix time => messages
+	authors map[string]int
+}
+
+// channelReport is a summary of a channel activity in a period.
+type channelReport struct {
+	Fleet         string
+	Channel       string
+	Start         time.Time
+	End           time.Time
+	Total         int
+	PreviousTotal int
+	Hours         []hourVolume
+	Peak          hourVolume
+	TopAuthors    []authorVolume
+	NewAuthors    []string
+	Anomalies     []string
+}
+
+type hourVolume struct {
+	Hour     time.Time
+	Messages int
+}
+
+type authorVolume struct {
+	Alias    string
+	Messages int
+}
+
+func newDigestCollector(stateFile string, start time.Time) (*digestCollector, error) {
+	c := &digestCollector{
+		stateFile: stateFile,
+		start:     start,
+		channels:  make(map[channelKey]*channelDigest),
+		state: digestState{
+			Channels: make(map[string]map[string]*channelState),
+		},
+	}
+
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.state); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *digestCollector) HandleMessage(m *receivedMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.channels[m.channel()]
+	if !ok {
+		d = &channelDigest{
+			hourly:  make(map[int64]int),
+			authors: make(map[string]int),
+		}
+		c.channels[m.channel()] = d
+	}
+
+	d.hourly[m.ReceivedAt.Truncate(time.Hour).Unix()]++
+	d.authors[m.Author]++
+}
+
+// Flush returns reports for all given channels for the period
+// from the previous flush until end and starts a new period.
+func (c *digestCollector) Flush(channels []channelKey, end time.Time) ([]*channelReport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reports []*channelReport
+	for _, ch := range channels {
+		d, ok := c.channels[ch]
+		if !ok {
+			d = &channelDigest{}
+		}
+		reports = append(reports, c.report(ch, d, end))
+	}
+
+	c.start = end
+	c.channels = make(map[channelKey]*channelDigest)
+
+	return reports, c.saveState()
+}
+
+func (c *digestCollector) report(ch channelKey, d *channelDigest, end time.Time) *channelReport {
+	state := c.state.channel(ch)
+	r := &channelReport{
+		Fleet:         ch.Fleet,
+		Channel:       ch.Chat,
+		Start:         c.start,
+		End:           end,
+		PreviousTotal: state.PreviousTotal,
+	}
+
+	var recent []int
+	for hour := c.start.Truncate(time.Hour); hour.Before(end); hour = hour.Add(time.Hour) {
+		v := hourVolume{Hour: hour, Messages: d.hourly[hour.Unix()]}
+		r.Hours = append(r.Hours, v)
+		r.Total += v.Messages
+		if v.Messages > r.Peak.Messages {
+			r.Peak = v
+		}
+
+		if len(recent) == digestDropWindow {
+			if avg := average(recent); avg >= digestDropMinAverage && float64(v.Messages) < avg*digestDropRatio {
+				r.Anomalies = append(r.Anomalies, formatDrop(v, avg))
+			}
+			recent = recent[1:]
+		}
+		recent = append(recent, v.Messages)
+	}
+
+	if r.PreviousTotal >= digestDropMinAverage && float64(r.Total) < float64(r.PreviousTotal)*digestDropRatio {
+		r.Anomalies = append(r.Anomalies, formatPeriodDrop(r))
+	}
+
+	known := state.KnownAuthors
+	for author, seen := range known {
+		if end.Sub(seen) > digestAuthorMemory {
+			delete(known, author)
+		}
+	}
+
+	for author, count := range d.authors {
+		alias := authorAlias(author)
+		r.TopAuthors = append(r.TopAuthors, authorVolume{Alias: alias, Messages: count})
+		if _, ok := known[author]; !ok {
+			r.NewAuthors = append(r.NewAuthors, alias)
+		}
+		known[author] = end
+	}
+	sort.Slice(r.TopAuthors, func(i, j int) bool {
+		if r.TopAuthors[i].Messages != r.TopAuthors[j].Messages {
+			return r.TopAuthors[i].Messages > r.TopAuthors[j].Messages
+		}
+		return r.TopAuthors[i].Alias < r.TopAuthors[j].Alias
+	})
+	if len(r.TopAuthors) > digestTopAuthors {
+		r.TopAuthors = r.TopAuthors[:digestTopAuthors]
+	}
+	sort.Strings(r.NewAuthors)
+
+	state.PreviousTotal = r.Total
+
+	return r
+}
+
+func (c *digestCollector) saveState() error {
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.stateFile), 0750); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.stateFile, data, 0640)
+}
+
+func average(values []int) float64 {
+	var sum int
+	for _, v := range values {
+		sum += v
+	}
+	return float64(sum) / float64(len(values))
+}
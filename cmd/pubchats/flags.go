@@ -1,19 +1,57 @@
 package main
 
 import (
+	"time"
+
 	"github.com/spf13/pflag"
 	"github.com/status-im/status-go/params"
+	"github.com/status-im/statusd-bots/metrics"
 )
 
 var (
-	datadir         = pflag.StringP("datadir", "d", "", "directory for data")
-	address         = pflag.StringP("addr", "a", "127.0.0.1:30303", "listener IP address")
-	fleet           = pflag.StringP("fleet", "f", params.FleetBeta, "cluster fleet")
-	trackedChannels = pflag.StringSliceP("channel", "c", []string{}, "public channels to track")
-	verbosity       = pflag.StringP("verbosity", "v", "INFO", "verbosity level of status-go, options: crit, error, warning, info, debug")
-	metricsAddr     = pflag.StringP("metrics-addr", "m", ":8080", "metrics server listening address")
+	datadir          = pflag.StringP("datadir", "d", "", "directory for data")
+	address          = pflag.StringP("addr", "a", "127.0.0.1:30303", "listener IP address")
+	fleetSpecs       = pflag.StringArrayP("fleet", "f", []string{params.FleetBeta}, "cluster fleet with optional channels tracked in it instead of --channel, e.g. eth.prod=status,status-core; can be repeated")
+	trackedChannels  = pflag.StringSliceP("channel", "c", []string{}, "public channels to track")
+	verbosity        = pflag.StringP("verbosity", "v", "INFO", "verbosity level of status-go, options: crit, error, warning, info, debug")
+	metricsAddr      = pflag.StringP("metrics-addr", "m", ":8080", "metrics server listening address")
+	metricsMaxSeries = pflag.Int("metrics-max-series", metrics.DefaultMaxSeries, "maximum number of series of a metric labeled with channels; samples of other channels are counted as \"__other__\"")
+	metricsSinks     = pflag.StringArray("metrics-sink", nil, "metrics sink, e.g. prometheus://:8080, pushgateway://host:9091, statsd://host:8125 or otlp://host:4318 (default serve on --metrics-addr)")
+	keyFile          = pflag.String("keyfile", "", "private key file of the bot identity, created if missing (default \"<datadir>/bot.key\")")
+	announcements    = pflag.String("announcements", "", "JSON file with scheduled announcements to post")
+	commandChannels  = pflag.StringSlice("command-channel", []string{}, "public channels in which slash commands are handled")
+	commandLimit     = pflag.Int("command-limit", 5, "maximum number of commands a user can issue per minute")
+	commandCooldown  = pflag.Duration("command-cooldown", 10*time.Second, "minimum time between the same command issued by a user")
+	reportDir        = pflag.String("report-dir", "", "directory to write channel digest reports to (reports are disabled if empty)")
+	reportInterval   = pflag.Duration("report-interval", 24*time.Hour, "period covered by a single digest report")
+	reportWebhook    = pflag.String("report-webhook", "", "URL to post digest reports to")
+	skewThreshold    = pflag.Duration("skew-threshold", time.Minute, "clock skew above which an author is reported")
+	archiveDir       = pflag.String("archive-dir", "", "directory to store received messages in (archiving is disabled if empty)")
+	archiveMaxAge    = pflag.Duration("archive-max-age", 0, "age after which messages are removed from the archive (0 keeps them forever)")
+	archiveMaxSize   = pflag.String("archive-max-size", "0", "maximum size of the archive of a channel, e.g. 500MB; the oldest days are removed first (0 means no limit)")
+	archiveRetention = pflag.String("archive-retention", "", "JSON file with retention limits per channel overriding --archive-max-age and --archive-max-size")
+	archiveCompact   = pflag.Duration("archive-compaction-interval", time.Hour, "how often retention limits are applied to the archive")
+	archiveExportDir = pflag.String("archive-export-dir", "", "directory to export removed messages to as gzipped JSONL (messages are not exported if empty)")
+	replayInput      = pflag.String("replay-input", "", "archive directory or JSONL file to replay (default --archive-dir)")
+	replayOutput     = pflag.String("replay-output", "-", "file to write replayed time series to")
+	replayFormat     = pflag.String("replay-format", "openmetrics", "format of replayed time series, options: openmetrics, csv")
+	replayStep       = pflag.Duration("replay-step", time.Minute, "interval between samples of replayed time series")
+	replayBucket     = pflag.Duration("replay-bucket", 24*time.Hour, "time span of OpenMetrics samples buffered in memory before they are spilled to a temporary file")
+	transcriptFrom   = pflag.String("transcript-from", "", "start of the transcript, an RFC 3339 time or a date (default 24 hours ago)")
+	transcriptTo     = pflag.String("transcript-to", "", "end of the transcript, an RFC 3339 time or a date (default now)")
+	transcriptFormat = pflag.String("transcript-format", "markdown", "format of the transcript, options: jsonl, csv, markdown")
+	transcriptOutput = pflag.String("transcript-output", "-", "file to write the transcript to")
+	transcriptSource = pflag.String("transcript-source", "archive", "where to take messages from, options: archive, mailserver")
+	transcriptServer = pflag.String("transcript-mailserver", "", "mail server to fetch history from (default a random one from the fleet)")
+	vantagePeers     = pflag.StringSlice("vantage", []string{}, "fleet nodes to pin vantage points to in the coverage mode (default all Whisper nodes of the fleet)")
+	coverageWindow   = pflag.Duration("coverage-window", time.Minute, "time after which an envelope not received by a vantage point is counted as missed")
+	coverageLogPath  = pflag.String("coverage-log", "", "JSONL file to record which vantage points received each envelope and when")
+	censusDictionary = pflag.String("census-dictionary", "", "file with candidate public chat names, one per line, to match topics against in the census mode")
+	censusMaxTopics  = pflag.Int("census-max-topics", 500, "maximum number of unmatched topics exported with their own label")
+	censusInterval   = pflag.Duration("census-interval", time.Second, "how often to collect envelopes in the census mode")
 )
 
 func init() {
 	pflag.Parse()
+	metrics.DefaultMaxSeries = *metricsMaxSeries
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/status-im/status-go/node"
+	"github.com/status-im/status-go/params"
+	"github.com/status-im/whisper/shhclient"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// fleetConfig is a fleet monitored by pubchats along with its channels.
+type fleetConfig struct {
+	Name     string
+	Channels []string
+}
+
+// parseFleets parses --fleet values in the form <fleet>[=<channel>,...].
+// Fleets without their own channels track the default ones.
+func parseFleets(values, defaultChannels []string) ([]fleetConfig, error) {
+	var fleets []fleetConfig
+	seen := make(map[string]struct{})
+
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+
+		f := fleetConfig{
+			Name:     strings.TrimSpace(parts[0]),
+			Channels: defaultChannels,
+		}
+		if f.Name == "" {
+			return nil, fmt.Errorf("empty fleet name in '%s'", v)
+		}
+		if _, ok := seen[f.Name]; ok {
+			return nil, fmt.Errorf("fleet '%s' given more than once", f.Name)
+		}
+		seen[f.Name] = struct{}{}
+
+		if len(parts) == 2 {
+			f.Channels = nil
+			for _, ch := range strings.Split(parts[1], ",") {
+				if ch = strings.TrimSpace(ch); ch != "" {
+					f.Channels = append(f.Channels, ch)
+				}
+			}
+		}
+
+		fleets = append(fleets, f)
+	}
+
+	if len(fleets) == 0 {
+		return nil, fmt.Errorf("no fleet given")
+	}
+	return fleets, nil
+}
+
+// mustParseFleets returns the fleets given with --fleet.
+func mustParseFleets() []fleetConfig {
+	fleets, err := parseFleets(*fleetSpecs, *trackedChannels)
+	if err != nil {
+		log.Fatalf("invalid fleets: %v", err)
+	}
+	return fleets
+}
+
+// mustParseSingleFleet returns the only fleet given with --fleet.
+// It is used by modes which compare nodes within a single fleet.
+func mustParseSingleFleet(mode string) fleetConfig {
+	fleets := mustParseFleets()
+	if len(fleets) > 1 {
+		log.Fatalf("the %s mode supports a single fleet", mode)
+	}
+	return fleets[0]
+}
+
+// allChannels returns channels tracked in any of the fleets.
+func allChannels(fleets []fleetConfig) []string {
+	var result []string
+	for _, f := range fleets {
+		for _, ch := range f.Channels {
+			if !containsString(result, ch) {
+				result = append(result, ch)
+			}
+		}
+	}
+	return result
+}
+
+// fleetChannels returns channels of all fleets.
+func fleetChannels(fleets []fleetConfig) []channelKey {
+	var result []channelKey
+	for _, f := range fleets {
+		for _, ch := range f.Channels {
+			result = append(result, channelKey{Fleet: f.Name, Chat: ch})
+		}
+	}
+	return result
+}
+
+// fleetMonitor is an embedded node connected to a fleet
+// which follows the fleet channels.
+type fleetMonitor struct {
+	fleetConfig
+
+	node   *node.StatusNode
+	shh    *shhclient.Client
+	topics map[whisper.TopicType]string
+}
+
+// startFleetMonitor starts a node connected to the fleet. If several fleets are
+// monitored, each node has its own data directory and all but the first one
+// listen on a random port.
+func startFleetMonitor(f fleetConfig, index, total int) (*fleetMonitor, error) {
+	config, err := newNodeConfig(f.Name, params.MainNetworkID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a config: %v", err)
+	}
+	if total > 1 {
+		if config.DataDir != "" {
+			config.DataDir = filepath.Join(config.DataDir, f.Name)
+		}
+		if index > 0 {
+			host, _, err := net.SplitHostPort(config.ListenAddr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid listen address: %v", err)
+			}
+			config.ListenAddr = net.JoinHostPort(host, "0")
+		}
+	}
+	log.Printf("using config for fleet %s: %v", f.Name, config)
+
+	topics, err := topicsToNames(f.Channels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topics to names mapping: %v", err)
+	}
+
+	n := node.New()
+	if err := n.Start(config); err != nil {
+		return nil, fmt.Errorf("failed to start a node: %v", err)
+	}
+
+	rpcClient, err := n.GethNode().Attach()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an rpc: %v", err)
+	}
+
+	return &fleetMonitor{
+		fleetConfig: f,
+		node:        n,
+		shh:         shhclient.NewClient(rpcClient),
+		topics:      topics,
+	}, nil
+}
+
+// Follow supervises subscriptions to the fleet channels and forwards
+// received messages until done is closed.
+func (m *fleetMonitor) Follow(out chan<- *receivedMessage, done <-chan struct{}, wg *sync.WaitGroup) {
+	messages := make(chan *whisper.Message)
+
+	for _, name := range m.Channels {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			superviseChannel(m.shh, m.Name, "", name, messages, done)
+		}(name)
+	}
+
+	go func() {
+		for {
+			select {
+			case msg := <-messages:
+				received := newReceivedMessage(m.Name, m.topics[msg.Topic], msg, time.Now())
+				select {
+				case out <- received:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
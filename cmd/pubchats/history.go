@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/status-im/status-go/node"
+	"github.com/status-im/status-go/params"
+	"github.com/status-im/status-go/services/shhext"
+	statussignal "github.com/status-im/status-go/signal"
+	"github.com/status-im/status-go/t/helpers"
+	"github.com/status-im/statusd-bots/mailserver"
+	"github.com/status-im/statusd-bots/protocol"
+	"github.com/status-im/whisper/shhclient"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+const (
+	historyPageLimit = 1000
+	// Envelopes are delivered before the request is completed, but they
+	// are decrypted asynchronously, so the last ones may arrive a bit later.
+	historyDeliveryGrace = 2 * time.Second
+)
+
+// fetchMessages requests history of the fleet channels from a mail server
+// the same way bench-mailserver does, following cursors until the whole
+// time range is fetched. If mailServer is empty, a random one from the fleet is used.
+func fetchMessages(fleet fleetConfig, mailServer string, from, to time.Time) ([]*receivedMessage, error) {
+	config, err := newNodeConfig(fleet.Name, params.MainNetworkID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a config: %v", err)
+	}
+
+	if mailServer == "" {
+		servers := config.ClusterConfig.TrustedMailServers
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("no mail servers in fleet %s", fleet.Name)
+		}
+		mailServer = servers[rand.Intn(len(servers))]
+	}
+	log.Printf("fetching history from %s", mailServer)
+
+	// Responses are buffered while nobody waits for them, for example
+	// late ones of timed out requests, and dropped when the buffer is full.
+	responses := make(chan mailserver.Response, 16)
+	statussignal.SetDefaultNodeNotificationHandler(mailserver.SignalHandler(logSignal, func(r mailserver.Response) {
+		select {
+		case responses <- r:
+		default:
+			log.Printf("dropped a %s response for request %s", r.Outcome, r.RequestHash)
+		}
+	}))
+
+	n := node.New()
+	if err := n.Start(config); err != nil {
+		return nil, fmt.Errorf("failed to start a node: %v", err)
+	}
+	defer n.Stop()
+
+	rpcClient, err := n.GethNode().Attach()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an rpc: %v", err)
+	}
+	shh := shhclient.NewClient(rpcClient)
+
+	shhextService, err := n.ShhExtService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an shhext service: %v", err)
+	}
+	shhextAPI := shhext.NewPublicAPI(shhextService)
+
+	topics, err := topicsToNames(fleet.Channels)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		messages []*receivedMessage
+	)
+	received := make(chan *whisper.Message)
+	for _, chat := range fleet.Channels {
+		symKeyID, err := addPublicChatSymKey(shh, chat)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add sym key for channel '%s': %v", chat, err)
+		}
+		sub, err := subscribeHistoryMessages(shh, chat, symKeyID, received)
+		if err != nil {
+			return nil, fmt.Errorf("failed to subscribe to messages for channel '%s': %v", chat, err)
+		}
+		defer sub.Unsubscribe()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case msg := <-received:
+				mu.Lock()
+				messages = append(messages, newReceivedMessage(fleet.Name, topics[msg.Topic], msg, time.Now()))
+				mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := n.AddPeer(mailServer); err != nil {
+		return nil, fmt.Errorf("failed to add Mail Server as a peer: %v", err)
+	}
+	errCh := helpers.WaitForPeerAsync(n.Server(), mailServer, p2p.PeerEventTypeAdd, 5*time.Second)
+	if err := <-errCh; err != nil {
+		return nil, fmt.Errorf("failed to wait for peer '%s': %v", mailServer, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	mailServerSymKeyID, err := shh.GenerateSymmetricKeyFromPassword(ctx, protocol.MailServerPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate sym key for mail server: %v", err)
+	}
+
+	matcher := mailserver.NewMatcher()
+	for _, chat := range fleet.Channels {
+		topic, err := protocol.PublicChatTopic([]byte(chat))
+		if err != nil {
+			return nil, err
+		}
+
+		for cursor, page := "", 1; ; page++ {
+			hash, err := mailserver.Send(shhextAPI, mailserver.Request{
+				MailServer: mailServer,
+				SymKeyID:   mailServerSymKeyID,
+				From:       from,
+				To:         to,
+				Limit:      historyPageLimit,
+				Cursor:     cursor,
+				Topics:     []whisper.TopicType{topic},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to request messages for channel '%s': %v", chat, err)
+			}
+
+			r, err := waitForResponse(responses, matcher, hash, 2*mailserver.RequestTimeout*time.Second)
+			if err != nil {
+				return nil, fmt.Errorf("request for channel '%s' failed: %v", chat, err)
+			}
+			log.Printf("fetched page %d of channel '%s'", page, chat)
+
+			if r.Cursor == "" {
+				break
+			}
+			cursor = r.Cursor
+		}
+	}
+
+	time.Sleep(historyDeliveryGrace)
+
+	mu.Lock()
+	defer mu.Unlock()
+	return messages, nil
+}
+
+// subscribeHistoryMessages subscribes to messages of the channel including
+// the ones sent directly by a mail server.
+func subscribeHistoryMessages(c *shhclient.Client, chat, symKeyID string, messages chan<- *whisper.Message) (ethereum.Subscription, error) {
+	topic, err := protocol.PublicChatTopic([]byte(chat))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.SubscribeMessages(ctx, whisper.Criteria{
+		SymKeyID: symKeyID,
+		MinPow:   0,
+		Topics:   []whisper.TopicType{topic},
+		AllowP2P: true,
+	}, messages)
+}
+
+// waitForResponse waits for the response of a sent request. Responses
+// of other requests are kept by the matcher until their requests are sent.
+func waitForResponse(responses <-chan mailserver.Response, matcher *mailserver.Matcher, hash string, timeout time.Duration) (mailserver.Response, error) {
+	r, ok := matcher.Sent(hash)
+	if !ok {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		for !ok {
+			select {
+			case r = <-responses:
+				ok = matcher.Match(r) == mailserver.Matched && r.RequestHash == hash
+			case <-timer.C:
+				matcher.Finish(hash)
+				return mailserver.Response{}, errors.New("timed out waiting for the mail server")
+			}
+		}
+	}
+
+	switch r.Outcome {
+	case mailserver.OutcomeExpired:
+		return r, errors.New("request expired")
+	case mailserver.OutcomeFailed:
+		return r, errors.New(r.Error)
+	}
+	return r, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/status-im/status-go/protocol/identity/alias"
+)
+
+// loadOrCreateKey returns a private key stored in the file.
+// If the file does not exist, a new key is generated and saved
+// so that the bot keeps the same identity between restarts.
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.LoadECDSA(path)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err = crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := crypto.SaveECDSA(path, key); err != nil {
+		return nil, err
+	}
+	log.Printf("generated a new bot identity in %s", path)
+
+	return key, nil
+}
+
+func keyFilePath() string {
+	if *keyFile != "" {
+		return *keyFile
+	}
+	return filepath.Join(*datadir, "bot.key")
+}
+
+// authorAlias returns a three words name which Status clients display
+// for the author given as a hex encoded public key.
+func authorAlias(author string) string {
+	name, err := alias.GenerateFromPublicKeyString("0x" + author)
+	if err != nil {
+		return author
+	}
+	return name
+}
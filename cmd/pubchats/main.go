@@ -2,18 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
+	"github.com/spf13/pflag"
 	"github.com/status-im/status-go/logutils"
-	"github.com/status-im/status-go/node"
-	"github.com/status-im/status-go/params"
 	statussignal "github.com/status-im/status-go/signal"
 	"github.com/status-im/statusd-bots/protocol"
 	"github.com/status-im/whisper/shhclient"
@@ -25,110 +24,194 @@ func init() {
 		log.Fatalf("failed to override root log: %v\n", err)
 	}
 
-	statussignal.SetDefaultNodeNotificationHandler(func(event string) {
-		log.Printf("received signal: %v\n", event)
-	})
+	statussignal.SetDefaultNodeNotificationHandler(logSignal)
 }
 
-func main() {
-	config, err := newNodeConfig(*fleet, params.MainNetworkID)
-	if err != nil {
-		log.Fatalf("failed to create a config: %v", err)
-	}
-	log.Printf("using config: %v", config)
+func logSignal(event string) {
+	log.Printf("received signal: %v\n", event)
+}
 
-	n := node.New()
-	if err := n.Start(config); err != nil {
-		log.Fatalf("failed to start a node: %v", err)
+func main() {
+	switch command := pflag.Arg(0); command {
+	case "":
+		runMonitor()
+	case "census":
+		runCensus()
+	case "coverage":
+		runCoverage()
+	case "replay":
+		input := *replayInput
+		if input == "" {
+			input = *archiveDir
+		}
+		fleet := mustParseFleets()[0].Name
+		if err := runReplay(input, fleet, *replayOutput, *replayFormat, *replayStep, *replayBucket); err != nil {
+			log.Fatalf("failed to replay messages: %v", err)
+		}
+	case "transcript":
+		if err := runTranscript(); err != nil {
+			log.Fatalf("failed to export a transcript: %v", err)
+		}
+	default:
+		log.Fatalf("unknown command '%s'", command)
 	}
+}
 
-	rpcClient, err := n.GethNode().Attach()
-	if err != nil {
-		log.Fatalf("failed to get an rpc: %v", err)
-	}
-	shh := shhclient.NewClient(rpcClient)
+func runMonitor() {
+	fleets := mustParseFleets()
 
-	// used to print a channel name from a whisper topic
-	log.Printf("tracked channels: %s", *trackedChannels)
-	topicsToNamesMap, err := topicsToNames(*trackedChannels)
-	if err != nil {
-		log.Fatalf("failed to get topics to names mapping: %v", err)
+	monitors := make([]*fleetMonitor, len(fleets))
+	for i, f := range fleets {
+		m, err := startFleetMonitor(f, i, len(fleets))
+		if err != nil {
+			log.Fatalf("failed to start monitoring fleet %s: %v", f.Name, err)
+		}
+		log.Printf("tracked channels in fleet %s: %s", f.Name, f.Channels)
+		monitors[i] = m
 	}
 
 	done := make(chan struct{})
-	messages := make(chan *whisper.Message)
-	subErr := make(chan error)
+	messages := make(chan *receivedMessage)
 
 	var wg sync.WaitGroup
 
-	for _, name := range *trackedChannels {
-		go func(name string) {
-			wg.Add(1)
-			defer wg.Done()
+	for _, m := range monitors {
+		m.Follow(messages, done, &wg)
+	}
 
-			symKeyID, err := addPublicChatSymKey(shh, name)
-			if err != nil {
-				log.Fatalf("failed to add sym key for channel '%s': %v", name, err)
-			}
+	handlers := newMetricsPipeline()
 
-			sub, err := subscribeMessages(shh, name, symKeyID, messages)
-			if err != nil {
-				log.Fatalf("failed to subscribe to messages for channel '%s': %v", name, err)
+	var archive *messageArchive
+	if *archiveDir != "" {
+		var err error
+		archive, err = newMessageArchive(*archiveDir)
+		if err != nil {
+			log.Fatalf("failed to open an archive: %v", err)
+		}
+		handlers = append(handlers, archive)
+
+		maxSize, err := parseSize(*archiveMaxSize)
+		if err != nil {
+			log.Fatalf("invalid archive max size: %v", err)
+		}
+		policies, err := loadRetentionPolicies(*archiveRetention, retentionPolicy{MaxAge: *archiveMaxAge, MaxSize: maxSize})
+		if err != nil {
+			log.Fatalf("failed to load retention policies: %v", err)
+		}
+
+		var exporter archiveExporter
+		if *archiveExportDir != "" {
+			exporter = &gzipExporter{dir: *archiveExportDir}
+		}
+
+		wg.Add(1)
+		go runCompaction(archive, policies, exporter, *archiveCompact, done, &wg)
+	}
+
+	if *reportDir != "" {
+		digest, err := newDigestCollector(filepath.Join(*reportDir, "state.json"), time.Now())
+		if err != nil {
+			log.Fatalf("failed to create a digest collector: %v", err)
+		}
+		go runReports(digest, fleetChannels(fleets), *reportInterval, *reportDir, *reportWebhook, done)
+		handlers = append(handlers, digest)
+	}
+
+	// Announcements and command replies are posted in every fleet
+	// by a publisher using the same bot identity.
+	fleetHandlers := make(map[string][]messageHandler)
+	if *announcements != "" || len(*commandChannels) > 0 {
+		for _, ch := range *commandChannels {
+			if !containsString(allChannels(fleets), ch) {
+				log.Fatalf("command channel '%s' is not tracked", ch)
 			}
-			defer sub.Unsubscribe()
+		}
 
-			select {
-			case err := <-sub.Err():
-				if err != nil {
-					subErr <- err
-				}
-			case <-done:
+		for _, m := range monitors {
+			pub := newBotPublisher(m.shh)
+			log.Printf("bot identity in fleet %s: %s", m.Name, pub.ID())
+
+			if *announcements != "" {
+				startAnnouncements(pub, *announcements, done)
 			}
-		}(name)
+			if d := newBotCommandDispatcher(pub, m.Name, m.Channels); d != nil {
+				fleetHandlers[m.Name] = append(fleetHandlers[m.Name], d)
+			}
+		}
 	}
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	go startMetricsServer(*metricsAddr)
+	sinks := startMetricsSinks()
 
 	log.Println("waiting for messages...")
 
-	// mapping chat => participants
-	chatParticipants := make(map[string]map[string]struct{})
-
 	for {
 		select {
-		case msg := <-messages:
-			chatName := topicsToNamesMap[msg.Topic]
-			source := hex.EncodeToString(msg.Sig)
-			log.Printf("received a message: topic=%v (%s) data=%s author=%s", msg.Topic, chatName, msg.Payload, source)
-			messagesCounter.WithLabelValues(chatName).Inc()
-
-			// detect unique participants per chat
-			var (
-				ok          bool
-				participans map[string]struct{}
-			)
-			participans, ok = chatParticipants[chatName]
-			if !ok {
-				participans = make(map[string]struct{})
-				chatParticipants[chatName] = participans
-			}
-			if _, ok := participans[source]; !ok {
-				uniqueCounter.WithLabelValues(chatName).Inc()
-				participans[source] = struct{}{}
-			}
-		case err := <-subErr:
-			log.Fatalf("subscription error: %v", err)
+		case m := <-messages:
+			log.Printf("received a message: fleet=%s chat=%s data=%s author=%s", m.Fleet, m.Chat, m.Payload, m.Author)
+			handleMessage(handlers, m)
+			handleMessage(fleetHandlers[m.Fleet], m)
 		case <-signals:
 			close(done)
 			wg.Wait()
+			if archive != nil {
+				if err := archive.Close(); err != nil {
+					log.Printf("failed to close the archive: %v", err)
+				}
+			}
+			closeMetricsSinks(sinks)
 			os.Exit(1)
 		}
 	}
 }
 
+// newBotPublisher creates a publisher posting as the bot identity.
+func newBotPublisher(shh *shhclient.Client) *publisher {
+	key, err := loadOrCreateKey(keyFilePath())
+	if err != nil {
+		log.Fatalf("failed to load bot identity: %v", err)
+	}
+
+	p, err := newPublisher(shh, key)
+	if err != nil {
+		log.Fatalf("failed to create a publisher: %v", err)
+	}
+	return p
+}
+
+func startAnnouncements(p *publisher, path string, done <-chan struct{}) {
+	list, err := loadAnnouncements(path)
+	if err != nil {
+		log.Fatalf("failed to load announcements: %v", err)
+	}
+
+	for _, a := range list {
+		log.Printf("scheduled announcement '%s' in channels %s", a.Name, a.Channels)
+		go runAnnouncement(a, p, done)
+	}
+}
+
+// newBotCommandDispatcher returns a dispatcher of commands from the command
+// channels tracked in the fleet, or nil if the fleet tracks none of them.
+func newBotCommandDispatcher(p *publisher, fleet string, trackedChannels []string) *commandDispatcher {
+	var channels []string
+	for _, ch := range *commandChannels {
+		if containsString(trackedChannels, ch) {
+			channels = append(channels, ch)
+		}
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	limiter := newRateLimiter(*commandLimit, time.Minute, *commandCooldown)
+	d := newCommandDispatcher(p, limiter, channels, p.ID())
+	registerBuiltinCommands(d, fleet, trackedChannels)
+	return d
+}
+
 func addPublicChatSymKey(c *shhclient.Client, chat string) (string, error) {
 	// This operation can be really slow, hence 10 seconds timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -2,31 +2,171 @@ package main
 
 import (
 	"log"
-	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/status-im/statusd-bots/metrics"
 )
 
+// Channel names come from user input and topics from the network,
+// so series of metrics labeled with them are limited.
 var (
-	messagesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	chatBudget   = metrics.BudgetOpts{Guarded: []string{"chat"}}
+	censusBudget = metrics.BudgetOpts{Guarded: []string{"topic", "chat"}}
+	authorBudget = metrics.BudgetOpts{Guarded: []string{"chat", "author"}}
+)
+
+var (
+	messagesCounter = metrics.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "shh",
 		Name:      "messages_total",
 		Help:      "Received messages counter.",
-	}, []string{"chat"})
-	uniqueCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	}, []string{"fleet", "chat"}, chatBudget)
+	uniqueCounter = metrics.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "shh",
 		Name:      "unique_authors_total",
 		Help:      "Unique authoers of the messages.",
-	}, []string{"chat"})
+	}, []string{"fleet", "chat"}, chatBudget)
+	contentTypeCounter = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "messages_by_content_type_total",
+		Help:      "Received messages counter by content type.",
+	}, []string{"fleet", "chat", "content_type"}, chatBudget)
+	payloadFormatCounter = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "messages_by_payload_format_total",
+		Help:      "Received messages counter by payload format.",
+	}, []string{"fleet", "chat", "payload_format"}, chatBudget)
+	clockSkewHistogram = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "shh",
+		Name:      "clock_skew_seconds",
+		Help:      "Difference between message clocks and the receive time or between each other.",
+		Buckets:   []float64{-3600, -600, -60, -10, -1, 0, 1, 10, 60, 600, 3600},
+	}, []string{"fleet", "chat", "source"}, chatBudget)
+	skewedAuthorsGauge = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "shh",
+		Name:      "skewed_authors",
+		Help:      "Number of authors whose last message timestamp exceeded the skew threshold.",
+	}, []string{"fleet", "chat"}, chatBudget)
+	authorSkewGauge = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "shh",
+		Name:      "author_clock_skew_seconds",
+		Help:      "Difference between the last message timestamp and the receive time of authors with skewed clocks.",
+	}, []string{"fleet", "chat", "author"}, authorBudget)
+	coverageObservedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "coverage_observed_envelopes_total",
+		Help:      "Envelopes received by at least one vantage point.",
+	}, []string{"fleet"})
+	coverageEnvelopesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "coverage_envelopes_total",
+		Help:      "Envelopes received by a vantage point pinned to a fleet node.",
+	}, []string{"fleet", "node"})
+	coverageMissedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "coverage_missed_envelopes_total",
+		Help:      "Envelopes received by other vantage points but not by this one within the window.",
+	}, []string{"fleet", "node"})
+	coverageLateCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "coverage_late_envelopes_total",
+		Help:      "Envelopes received by this vantage point after the window, already counted as missed.",
+	}, []string{"fleet", "node"})
+	coverageDelayHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "shh",
+		Name:      "coverage_delay_seconds",
+		Help:      "Delay of delivering an envelope relative to the first vantage point which received it.",
+		Buckets:   []float64{0, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"fleet", "node"})
+	censusEnvelopesCounter = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "census_envelopes_total",
+		Help:      "Envelopes received by topic.",
+	}, []string{"fleet", "topic", "chat"}, censusBudget)
+	censusBytesCounter = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "census_bytes_total",
+		Help:      "Size of envelopes received by topic.",
+	}, []string{"fleet", "topic", "chat"}, censusBudget)
+	subscriptionReconnectsCounter = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shh",
+		Name:      "subscription_reconnects_total",
+		Help:      "Attempts to re-create a failed channel subscription.",
+	}, []string{"fleet", "node", "chat"}, chatBudget)
+	subscriptionStateGauge = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "shh",
+		Name:      "subscription_state",
+		Help:      "Current state of a channel subscription, set to 1 for the current state.",
+	}, []string{"fleet", "node", "chat", "state"}, chatBudget)
 )
 
 func init() {
 	prometheus.MustRegister(messagesCounter)
 	prometheus.MustRegister(uniqueCounter)
+	prometheus.MustRegister(contentTypeCounter)
+	prometheus.MustRegister(payloadFormatCounter)
+	prometheus.MustRegister(clockSkewHistogram)
+	prometheus.MustRegister(skewedAuthorsGauge)
+	prometheus.MustRegister(authorSkewGauge)
+	prometheus.MustRegister(coverageObservedCounter)
+	prometheus.MustRegister(coverageEnvelopesCounter)
+	prometheus.MustRegister(coverageMissedCounter)
+	prometheus.MustRegister(coverageLateCounter)
+	prometheus.MustRegister(coverageDelayHistogram)
+	prometheus.MustRegister(censusEnvelopesCounter)
+	prometheus.MustRegister(censusBytesCounter)
+	prometheus.MustRegister(subscriptionReconnectsCounter)
+	prometheus.MustRegister(subscriptionStateGauge)
+}
+
+// metricsHandler counts messages and unique authors.
+type metricsHandler struct {
+	// mapping channel => participants
+	chatParticipants map[channelKey]map[string]struct{}
+}
+
+func newMetricsHandler() *metricsHandler {
+	return &metricsHandler{
+		chatParticipants: make(map[channelKey]map[string]struct{}),
+	}
+}
+
+func (h *metricsHandler) HandleMessage(m *receivedMessage) {
+	messagesCounter.WithLabelValues(m.Fleet, m.Chat).Inc()
+
+	payload := m.Decoded()
+	contentTypeCounter.WithLabelValues(m.Fleet, m.Chat, payload.ContentType).Inc()
+	payloadFormatCounter.WithLabelValues(m.Fleet, m.Chat, payload.Format).Inc()
+
+	// detect unique participants per chat
+	participants, ok := h.chatParticipants[m.channel()]
+	if !ok {
+		participants = make(map[string]struct{})
+		h.chatParticipants[m.channel()] = participants
+	}
+	if _, ok := participants[m.Author]; !ok {
+		uniqueCounter.WithLabelValues(m.Fleet, m.Chat).Inc()
+		participants[m.Author] = struct{}{}
+	}
+}
+
+// startMetricsSinks starts the configured metrics sinks. If none is configured,
+// metrics are served to be scraped on the metrics address.
+func startMetricsSinks() metrics.Sinks {
+	specs := *metricsSinks
+	if len(specs) == 0 {
+		specs = []string{"prometheus://" + *metricsAddr}
+	}
+	sinks, err := metrics.StartSinks(specs, "pubchats", prometheus.DefaultGatherer)
+	if err != nil {
+		log.Fatalf("failed to start metrics sinks: %v", err)
+	}
+	return sinks
 }
 
-func startMetricsServer(addr string) {
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(addr, nil))
+// closeMetricsSinks pushes metrics for the last time before the process exits.
+func closeMetricsSinks(sinks metrics.Sinks) {
+	if err := sinks.Close(); err != nil {
+		log.Printf("failed to close metrics sinks: %v", err)
+	}
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/status-im/status-go/protocol/protobuf"
+	v1protocol "github.com/status-im/status-go/protocol/v1"
+)
+
+// encodeTextMessage builds a signed payload of a plain text message
+// sent to a public chat, in a format understood by Status clients.
+func encodeTextMessage(key *ecdsa.PrivateKey, chat, text string, clock uint64, now time.Time) ([]byte, error) {
+	payload, err := proto.Marshal(&protobuf.ChatMessage{
+		Clock:       clock,
+		Timestamp:   v1protocol.TimestampInMsFromTime(now),
+		Text:        text,
+		ChatId:      chat,
+		MessageType: protobuf.ChatMessage_PUBLIC_GROUP,
+		ContentType: protobuf.ChatMessage_TEXT_PLAIN,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v1protocol.WrapMessageV1(payload, protobuf.ApplicationMetadataMessage_CHAT_MESSAGE, key)
+}
+
+// Content types used as metric labels.
+const (
+	contentTypeText        = "text"
+	contentTypeSticker     = "sticker"
+	contentTypeImage       = "image"
+	contentTypeEmoji       = "emoji"
+	contentTypeSystem      = "system"
+	contentTypeTransaction = "transaction"
+	contentTypeContact     = "contact"
+	contentTypeMembership  = "membership"
+	contentTypePairing     = "pairing"
+	contentTypeUnknown     = "unknown"
+	contentTypeUndecodable = "undecodable"
+)
+
+// Payload formats used as metric labels.
+const (
+	payloadFormatUnknown = "unknown"
+	payloadFormatV0      = "v0" // transit encoded, used by older clients
+	payloadFormatV1      = "v1" // protobuf encoded
+)
+
+// Content types added to the protocol after status-go v0.48.2, which
+// this project depends on and whose ChatMessage_ContentType ends with
+// TRANSACTION_COMMAND = 5. Values and names follow the later protocol.
+const (
+	chatMessageSystemMessagePrivateGroup protobuf.ChatMessage_ContentType = 6
+	chatMessageImage                     protobuf.ChatMessage_ContentType = 7
+)
+
+var transitMessagePrefix = []byte(`["~#c4",`)
+
+// decodedPayload describes a message payload.
+// Clock and Timestamp are zero if the payload could not be decoded.
+type decodedPayload struct {
+	ContentType string
+	Format      string
+	Text        string
+	Clock       uint64 // Lamport clock
+	Timestamp   uint64 // in milliseconds, as set by the sender
+}
+
+// decodePayload recognizes both protobuf and legacy transit payloads.
+func decodePayload(payload []byte) decodedPayload {
+	if bytes.HasPrefix(payload, transitMessagePrefix) {
+		if result, err := decodeTransitPayload(payload); err == nil {
+			return result
+		}
+		return decodedPayload{ContentType: contentTypeUndecodable, Format: payloadFormatV0}
+	}
+
+	var metadata protobuf.ApplicationMetadataMessage
+	if err := proto.Unmarshal(payload, &metadata); err != nil || len(metadata.Payload) == 0 {
+		return decodedPayload{ContentType: contentTypeUndecodable, Format: payloadFormatUnknown}
+	}
+
+	if metadata.Type != protobuf.ApplicationMetadataMessage_CHAT_MESSAGE {
+		return decodedPayload{ContentType: applicationMessageContentType(metadata.Type), Format: payloadFormatV1}
+	}
+
+	var message protobuf.ChatMessage
+	if err := proto.Unmarshal(metadata.Payload, &message); err != nil {
+		return decodedPayload{ContentType: contentTypeUndecodable, Format: payloadFormatV1}
+	}
+
+	return decodedPayload{
+		ContentType: chatMessageContentType(message.ContentType),
+		Format:      payloadFormatV1,
+		Text:        message.Text,
+		Clock:       message.Clock,
+		Timestamp:   message.Timestamp,
+	}
+}
+
+func chatMessageContentType(t protobuf.ChatMessage_ContentType) string {
+	switch t {
+	case protobuf.ChatMessage_TEXT_PLAIN, protobuf.ChatMessage_STATUS:
+		return contentTypeText
+	case protobuf.ChatMessage_STICKER:
+		return contentTypeSticker
+	case protobuf.ChatMessage_EMOJI:
+		return contentTypeEmoji
+	case protobuf.ChatMessage_TRANSACTION_COMMAND:
+		return contentTypeTransaction
+	case chatMessageSystemMessagePrivateGroup:
+		return contentTypeSystem
+	case chatMessageImage:
+		return contentTypeImage
+	default:
+		return contentTypeUnknown
+	}
+}
+
+// applicationMessageContentType returns the content type of a message
+// other than a chat message, as listed in status-go v0.48.2.
+func applicationMessageContentType(t protobuf.ApplicationMetadataMessage_Type) string {
+	switch t {
+	case protobuf.ApplicationMetadataMessage_CONTACT_UPDATE:
+		return contentTypeContact
+	case protobuf.ApplicationMetadataMessage_MEMBERSHIP_UPDATE_MESSAGE:
+		return contentTypeMembership
+	case protobuf.ApplicationMetadataMessage_PAIR_INSTALLATION,
+		protobuf.ApplicationMetadataMessage_SYNC_INSTALLATION,
+		protobuf.ApplicationMetadataMessage_SYNC_INSTALLATION_CONTACT,
+		protobuf.ApplicationMetadataMessage_SYNC_INSTALLATION_ACCOUNT,
+		protobuf.ApplicationMetadataMessage_SYNC_INSTALLATION_PUBLIC_CHAT:
+		return contentTypePairing
+	case protobuf.ApplicationMetadataMessage_REQUEST_ADDRESS_FOR_TRANSACTION,
+		protobuf.ApplicationMetadataMessage_ACCEPT_REQUEST_ADDRESS_FOR_TRANSACTION,
+		protobuf.ApplicationMetadataMessage_DECLINE_REQUEST_ADDRESS_FOR_TRANSACTION,
+		protobuf.ApplicationMetadataMessage_REQUEST_TRANSACTION,
+		protobuf.ApplicationMetadataMessage_SEND_TRANSACTION,
+		protobuf.ApplicationMetadataMessage_DECLINE_REQUEST_TRANSACTION:
+		return contentTypeTransaction
+	default:
+		return contentTypeUnknown
+	}
+}
+
+// decodeTransitPayload decodes a legacy message which is a transit encoded array:
+// ["~#c4",[text, content type, message type, clock, timestamp, content]]
+func decodeTransitPayload(payload []byte) (decodedPayload, error) {
+	result := decodedPayload{Format: payloadFormatV0}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var value []interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return result, err
+	}
+	if len(value) != 2 {
+		return result, fmt.Errorf("expected 2 elements, got %d", len(value))
+	}
+	fields, ok := value[1].([]interface{})
+	if !ok || len(fields) < 5 {
+		return result, fmt.Errorf("invalid message fields")
+	}
+
+	result.Text, _ = fields[0].(string)
+
+	switch fields[1] {
+	case "text/plain", "status":
+		result.ContentType = contentTypeText
+	case "sticker":
+		result.ContentType = contentTypeSticker
+	case "emoji":
+		result.ContentType = contentTypeEmoji
+	default:
+		result.ContentType = contentTypeUnknown
+	}
+
+	result.Clock = transitUint(fields[3])
+	result.Timestamp = transitUint(fields[4])
+
+	return result, nil
+}
+
+func transitUint(v interface{}) uint64 {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0
+	}
+	i, err := strconv.ParseUint(string(n), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
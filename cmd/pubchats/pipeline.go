@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/hex"
+	"time"
+
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// receivedMessage is a message received in a tracked channel.
+// It is also the format of records stored in the archive.
+type receivedMessage struct {
+	Fleet      string    `json:"fleet,omitempty"`
+	Chat       string    `json:"chat"`
+	Author     string    `json:"author"` // hex encoded public key
+	Hash       string    `json:"hash"`
+	Timestamp  uint32    `json:"timestamp"` // envelope timestamp
+	ReceivedAt time.Time `json:"receivedAt"`
+	Payload    []byte    `json:"payload"`
+
+	decoded *decodedPayload
+}
+
+func newReceivedMessage(fleet, chat string, msg *whisper.Message, receivedAt time.Time) *receivedMessage {
+	return &receivedMessage{
+		Fleet:      fleet,
+		Chat:       chat,
+		Author:     hex.EncodeToString(msg.Sig),
+		Hash:       hex.EncodeToString(msg.Hash),
+		Timestamp:  msg.Timestamp,
+		ReceivedAt: receivedAt,
+		Payload:    msg.Payload,
+	}
+}
+
+// Decoded returns the decoded payload. It is decoded only once.
+func (m *receivedMessage) Decoded() decodedPayload {
+	if m.decoded == nil {
+		d := decodePayload(m.Payload)
+		m.decoded = &d
+	}
+	return *m.decoded
+}
+
+// channelKey identifies a channel in a fleet.
+type channelKey struct {
+	Fleet string
+	Chat  string
+}
+
+func (m *receivedMessage) channel() channelKey {
+	return channelKey{Fleet: m.Fleet, Chat: m.Chat}
+}
+
+// messageHandler processes received messages. Handlers must not rely
+// on the current time but on receivedMessage.ReceivedAt instead,
+// so that they can be used to replay archived messages.
+type messageHandler interface {
+	HandleMessage(*receivedMessage)
+}
+
+// newMetricsPipeline returns handlers which compute metrics.
+// The same pipeline is used for live messages and replays.
+func newMetricsPipeline() []messageHandler {
+	return []messageHandler{
+		newMetricsHandler(),
+		newSkewHandler(*skewThreshold),
+	}
+}
+
+func handleMessage(handlers []messageHandler, m *receivedMessage) {
+	for _, h := range handlers {
+		h.HandleMessage(m)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	v1protocol "github.com/status-im/status-go/protocol/v1"
+	"github.com/status-im/statusd-bots/protocol"
+	"github.com/status-im/whisper/shhclient"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+const (
+	// Values used by Status clients for public chat messages.
+	messageTTL     = 10
+	messagePoW     = 0.002
+	messagePoWTime = 1
+)
+
+// publisher posts messages to public chats on behalf of the bot identity.
+type publisher struct {
+	shh   *shhclient.Client
+	key   *ecdsa.PrivateKey
+	keyID string
+
+	mu      sync.Mutex
+	symKeys map[string]string // chat => sym key ID
+	clocks  map[string]uint64 // chat => last used Lamport clock
+}
+
+func newPublisher(shh *shhclient.Client, key *ecdsa.PrivateKey) (*publisher, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	keyID, err := shh.AddPrivateKey(ctx, crypto.FromECDSA(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &publisher{
+		shh:     shh,
+		key:     key,
+		keyID:   keyID,
+		symKeys: make(map[string]string),
+		clocks:  make(map[string]uint64),
+	}, nil
+}
+
+// ID returns the public key of the bot in the same format
+// as authors of received messages are reported.
+func (p *publisher) ID() string {
+	return hex.EncodeToString(crypto.FromECDSAPub(&p.key.PublicKey))
+}
+
+// Publish sends a text message to the public chat.
+func (p *publisher) Publish(chat, text string) error {
+	symKeyID, err := p.symKey(chat)
+	if err != nil {
+		return err
+	}
+
+	topic, err := protocol.PublicChatTopic([]byte(chat))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	payload, err := encodeTextMessage(p.key, chat, text, p.nextClock(chat, now), now)
+	if err != nil {
+		return err
+	}
+
+	// Calculating PoW takes some time, hence a longer timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = p.shh.Post(ctx, whisper.NewMessage{
+		SymKeyID:  symKeyID,
+		Sig:       p.keyID,
+		TTL:       messageTTL,
+		Topic:     topic,
+		Payload:   payload,
+		PowTime:   messagePoWTime,
+		PowTarget: messagePoW,
+	})
+	return err
+}
+
+func (p *publisher) symKey(chat string) (string, error) {
+	p.mu.Lock()
+	symKeyID, ok := p.symKeys[chat]
+	p.mu.Unlock()
+	if ok {
+		return symKeyID, nil
+	}
+
+	symKeyID, err := addPublicChatSymKey(p.shh, chat)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	p.symKeys[chat] = symKeyID
+	p.mu.Unlock()
+
+	return symKeyID, nil
+}
+
+func (p *publisher) nextClock(chat string, now time.Time) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clock := v1protocol.CalcMessageClock(p.clocks[chat], v1protocol.TimestampInMsFromTime(now))
+	p.clocks[chat] = clock
+	return clock
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter limits how often a user can issue commands.
+// A user can issue at most limit commands within the window,
+// and the same command not more often than once per cooldown.
+type rateLimiter struct {
+	limit    int
+	window   time.Duration
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	history  map[string][]time.Time // user => recent commands
+	lastUsed map[string]time.Time   // user and command => last use
+	lastGC   time.Time
+}
+
+func newRateLimiter(limit int, window, cooldown time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:    limit,
+		window:   window,
+		cooldown: cooldown,
+		history:  make(map[string][]time.Time),
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+// Allow reports whether the user can issue the command now.
+// If it returns true, the command is recorded.
+func (l *rateLimiter) Allow(user, cmd string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := user + "/" + cmd
+	if last, ok := l.lastUsed[key]; ok && now.Sub(last) < l.cooldown {
+		return false
+	}
+
+	recent := l.history[user][:0]
+	for _, t := range l.history[user] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if l.limit > 0 && len(recent) >= l.limit {
+		l.history[user] = recent
+		return false
+	}
+
+	l.history[user] = append(recent, now)
+	l.lastUsed[key] = now
+	if now.Sub(l.lastGC) >= l.retention() {
+		l.gc(now)
+		l.lastGC = now
+	}
+
+	return true
+}
+
+// retention returns how long a command affects later ones.
+// It is also the interval between garbage collections, so that their
+// cost is amortized over the commands issued in between.
+func (l *rateLimiter) retention() time.Duration {
+	if l.cooldown > l.window {
+		return l.cooldown
+	}
+	return l.window
+}
+
+// gc removes users who have not issued any commands recently,
+// so that the maps do not grow indefinitely.
+func (l *rateLimiter) gc(now time.Time) {
+	for key, last := range l.lastUsed {
+		if now.Sub(last) >= l.retention() {
+			delete(l.lastUsed, key)
+		}
+	}
+	for user, times := range l.history {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= l.window {
+			delete(l.history, user)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type command struct {
+		user string
+		cmd  string
+		at   time.Duration
+		want bool
+	}
+
+	for _, tc := range []struct {
+		name     string
+		limit    int
+		window   time.Duration
+		cooldown time.Duration
+		commands []command
+	}{
+		{
+			name:   "limit within the window",
+			limit:  2,
+			window: time.Minute,
+			commands: []command{
+				{"alice", "stats", 0, true},
+				{"alice", "help", time.Second, true},
+				{"alice", "top", 2 * time.Second, false},
+				{"bob", "top", 2 * time.Second, true},
+			},
+		},
+		{
+			name:   "window slides",
+			limit:  2,
+			window: time.Minute,
+			commands: []command{
+				{"alice", "stats", 0, true},
+				{"alice", "help", 30 * time.Second, true},
+				{"alice", "top", 59 * time.Second, false},
+				{"alice", "top", time.Minute, true},
+				{"alice", "stats", 89 * time.Second, false},
+				{"alice", "stats", 90 * time.Second, true},
+			},
+		},
+		{
+			name:   "denied commands do not count",
+			limit:  1,
+			window: time.Minute,
+			commands: []command{
+				{"alice", "stats", 0, true},
+				{"alice", "stats", 30 * time.Second, false},
+				{"alice", "stats", time.Minute, true},
+			},
+		},
+		{
+			name:     "cooldown per command",
+			window:   time.Minute,
+			cooldown: 10 * time.Second,
+			commands: []command{
+				{"alice", "stats", 0, true},
+				{"alice", "stats", 9 * time.Second, false},
+				{"alice", "help", 9 * time.Second, true},
+				{"bob", "stats", 9 * time.Second, true},
+				{"alice", "stats", 10 * time.Second, true},
+			},
+		},
+		{
+			name:     "cooldown longer than the window",
+			limit:    1,
+			window:   time.Second,
+			cooldown: time.Minute,
+			commands: []command{
+				{"alice", "stats", 0, true},
+				{"alice", "help", 2 * time.Second, true},
+				{"bob", "stats", 3 * time.Second, true},
+				{"alice", "stats", 30 * time.Second, false},
+				{"alice", "stats", time.Minute, true},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newRateLimiter(tc.limit, tc.window, tc.cooldown)
+			for i, c := range tc.commands {
+				if got := l.Allow(c.user, c.cmd, start.Add(c.at)); got != c.want {
+					t.Errorf("command %d: Allow(%s, %s) at %s = %v, want %v", i, c.user, c.cmd, c.at, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterGC(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(10, time.Minute, 10*time.Second)
+
+	for _, c := range []struct {
+		user    string
+		cmd     string
+		at      time.Duration
+		tracked []string // users tracked after the command
+	}{
+		{"carol", "stats", 0, []string{"carol"}},
+		{"alice", "stats", 10 * time.Second, []string{"alice", "carol"}},
+		// Collected a window after the previous collection.
+		{"bob", "stats", 65 * time.Second, []string{"alice", "bob"}},
+		// Alice is inactive for a window, but it is not time to collect yet.
+		{"bob", "help", 100 * time.Second, []string{"alice", "bob"}},
+		{"dave", "stats", 125 * time.Second, []string{"bob", "dave"}},
+	} {
+		if !l.Allow(c.user, c.cmd, start.Add(c.at)) {
+			t.Fatalf("Allow(%s, %s) at %s = false", c.user, c.cmd, c.at)
+		}
+		if len(l.history) != len(c.tracked) {
+			t.Errorf("at %s: tracked %d users, want %v", c.at, len(l.history), c.tracked)
+		}
+		for _, user := range c.tracked {
+			if _, ok := l.history[user]; !ok {
+				t.Errorf("at %s: %s is not tracked", c.at, user)
+			}
+		}
+	}
+	if _, ok := l.lastUsed["alice/stats"]; ok {
+		t.Errorf("last use of a command of an inactive user is not forgotten")
+	}
+}
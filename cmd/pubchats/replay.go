@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Only metrics with this prefix are replayed. Other metrics,
+// like the Go runtime ones, have no meaning in a replay.
+const replayMetricsPrefix = "shh_"
+
+// runReplay passes messages from the input through the metrics pipeline
+// using a simulated clock driven by the receive time of the messages.
+// Metrics are sampled every step and written as time series to the output.
+// OpenMetrics samples are buffered in memory for a bucket of time at most.
+// Messages archived without a fleet are attributed to the given one.
+func runReplay(input, fleet, output, format string, step, bucket time.Duration) error {
+	if step <= 0 {
+		return errors.New("step must be positive")
+	}
+	if bucket < step {
+		return errors.New("bucket must not be shorter than the step")
+	}
+
+	var writer timeSeriesWriter
+	switch format {
+	case "openmetrics":
+		writer = newOpenMetricsWriter(bucket)
+	case "csv":
+		writer = newCSVWriter()
+	default:
+		return fmt.Errorf("unknown format '%s'", format)
+	}
+	defer func() {
+		if err := writer.Release(); err != nil {
+			log.Printf("failed to release the %s writer: %v", format, err)
+		}
+	}()
+
+	out := os.Stdout
+	if output != "" && output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	handlers := newMetricsPipeline()
+
+	var (
+		next  time.Time // next sample time
+		last  time.Time // simulated clock
+		count int
+	)
+
+	sample := func(t time.Time) error {
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			return err
+		}
+		return writer.Write(out, t, families)
+	}
+
+	err := readMessages(input, func(m *receivedMessage) error {
+		if m.Fleet == "" {
+			m.Fleet = fleet
+		}
+
+		// The clock never goes back, even if a log is not ordered.
+		if m.ReceivedAt.After(last) {
+			last = m.ReceivedAt
+		}
+		if next.IsZero() {
+			next = last.Truncate(step).Add(step)
+		}
+		for !next.After(last) {
+			if err := sample(next); err != nil {
+				return err
+			}
+			next = next.Add(step)
+		}
+
+		handleMessage(handlers, m)
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		if err := sample(next); err != nil {
+			return err
+		}
+	}
+	log.Printf("replayed %d messages", count)
+
+	return writer.Close(out)
+}
+
+// timeSeriesWriter writes samples of metrics taken at consecutive times.
+type timeSeriesWriter interface {
+	Write(w io.Writer, t time.Time, families []*dto.MetricFamily) error
+	// Close writes the rest of the output.
+	Close(w io.Writer) error
+	// Release frees resources of the writer, whether it was closed or not.
+	Release() error
+}
+
+type sample struct {
+	Name   string
+	Labels []*dto.LabelPair
+	Value  float64
+}
+
+// familySamples flattens a metric family into samples
+// named according to the Prometheus conventions.
+func familySamples(f *dto.MetricFamily) []sample {
+	var result []sample
+	for _, m := range f.GetMetric() {
+		result = append(result, metricSamples(f, m)...)
+	}
+	return result
+}
+
+func metricSamples(f *dto.MetricFamily, m *dto.Metric) []sample {
+	name := f.GetName()
+	labels := m.GetLabel()
+
+	switch f.GetType() {
+	case dto.MetricType_COUNTER:
+		return []sample{{name, labels, m.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []sample{{name, labels, m.GetGauge().GetValue()}}
+	case dto.MetricType_UNTYPED:
+		return []sample{{name, labels, m.GetUntyped().GetValue()}}
+	case dto.MetricType_HISTOGRAM:
+		var result []sample
+		h := m.GetHistogram()
+		for _, b := range h.GetBucket() {
+			result = append(result, sample{name + "_bucket", withLabel(labels, "le", formatFloat(b.GetUpperBound())), float64(b.GetCumulativeCount())})
+		}
+		return append(result,
+			sample{name + "_bucket", withLabel(labels, "le", "+Inf"), float64(h.GetSampleCount())},
+			sample{name + "_count", labels, float64(h.GetSampleCount())},
+			sample{name + "_sum", labels, h.GetSampleSum()},
+		)
+	case dto.MetricType_SUMMARY:
+		var result []sample
+		s := m.GetSummary()
+		for _, q := range s.GetQuantile() {
+			result = append(result, sample{name, withLabel(labels, "quantile", formatFloat(q.GetQuantile())), q.GetValue()})
+		}
+		return append(result,
+			sample{name + "_count", labels, float64(s.GetSampleCount())},
+			sample{name + "_sum", labels, s.GetSampleSum()},
+		)
+	}
+	return nil
+}
+
+func withLabel(labels []*dto.LabelPair, name, value string) []*dto.LabelPair {
+	result := make([]*dto.LabelPair, len(labels), len(labels)+1)
+	copy(result, labels)
+	return append(result, &dto.LabelPair{Name: &name, Value: &value})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func formatLabels(labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = fmt.Sprintf(`%s="%s"`, l.GetName(), escaper.Replace(l.GetValue()))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// openMetricsWriter writes samples in the OpenMetrics format, which requires
+// samples of a metric family, and of each of its label sets, to be written
+// together. Samples of a time bucket are buffered, sorted by series and
+// spilled to a temporary file; on Close the files are merged series by series,
+// so that only one bucket is kept in memory.
+type openMetricsWriter struct {
+	bucket   time.Duration
+	order    []string
+	families map[string]*openMetricsFamily
+
+	dir     string    // of bucket files
+	files   []string  // bucket files in the order of time
+	start   time.Time // of the current bucket
+	samples map[openMetricsSeries][]string
+}
+
+type openMetricsFamily struct {
+	index  int
+	typ    string
+	help   string
+	series map[string]int // label set => index
+	count  int
+}
+
+// openMetricsSeries identifies a label set by the order in which
+// its family and the label set itself were first sampled.
+type openMetricsSeries struct {
+	family int
+	labels int
+}
+
+func newOpenMetricsWriter(bucket time.Duration) *openMetricsWriter {
+	return &openMetricsWriter{
+		bucket:   bucket,
+		families: make(map[string]*openMetricsFamily),
+		samples:  make(map[openMetricsSeries][]string),
+	}
+}
+
+func (w *openMetricsWriter) Write(_ io.Writer, t time.Time, families []*dto.MetricFamily) error {
+	if start := t.Truncate(w.bucket); !start.Equal(w.start) {
+		if err := w.spill(); err != nil {
+			return err
+		}
+		w.start = start
+	}
+
+	for _, f := range families {
+		if !strings.HasPrefix(f.GetName(), replayMetricsPrefix) {
+			continue
+		}
+
+		name, typ := f.GetName(), "unknown"
+		switch f.GetType() {
+		case dto.MetricType_COUNTER:
+			// OpenMetrics counter families are named without the suffix.
+			name, typ = strings.TrimSuffix(name, "_total"), "counter"
+		case dto.MetricType_GAUGE:
+			typ = "gauge"
+		case dto.MetricType_HISTOGRAM:
+			typ = "histogram"
+		case dto.MetricType_SUMMARY:
+			typ = "summary"
+		}
+
+		family, ok := w.families[name]
+		if !ok {
+			family = &openMetricsFamily{index: len(w.order), typ: typ, help: f.GetHelp(), series: make(map[string]int)}
+			w.families[name] = family
+			w.order = append(w.order, name)
+		}
+
+		for _, m := range f.GetMetric() {
+			key := formatLabels(m.GetLabel())
+			labels, ok := family.series[key]
+			if !ok {
+				labels = family.count
+				family.series[key] = labels
+				family.count++
+			}
+			series := openMetricsSeries{family.index, labels}
+			for _, s := range metricSamples(f, m) {
+				w.samples[series] = append(w.samples[series], fmt.Sprintf("%s%s %s %d",
+					s.Name, formatLabels(s.Labels), formatFloat(s.Value), t.Unix()))
+			}
+		}
+	}
+	return nil
+}
+
+// spill writes samples of the current bucket to a file, ordered by series.
+// Each line is prefixed with the series.
+func (w *openMetricsWriter) spill() error {
+	if len(w.samples) == 0 {
+		return nil
+	}
+	if w.dir == "" {
+		dir, err := ioutil.TempDir("", "pubchats-replay")
+		if err != nil {
+			return err
+		}
+		w.dir = dir
+	}
+
+	series := make([]openMetricsSeries, 0, len(w.samples))
+	for s := range w.samples {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].less(series[j]) })
+
+	path := filepath.Join(w.dir, strconv.Itoa(len(w.files)))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := bufio.NewWriter(f)
+	for _, s := range series {
+		for _, line := range w.samples[s] {
+			if _, err := fmt.Fprintf(buf, "%d %d %s\n", s.family, s.labels, line); err != nil {
+				return err
+			}
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	w.files = append(w.files, path)
+	w.samples = make(map[openMetricsSeries][]string)
+	return nil
+}
+
+func (w *openMetricsWriter) Close(out io.Writer) error {
+	if err := w.spill(); err != nil {
+		return err
+	}
+
+	buckets := make([]*openMetricsBucket, len(w.files))
+	for i, path := range w.files {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		buckets[i] = &openMetricsBucket{r: bufio.NewReader(f)}
+		if err := buckets[i].next(); err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+
+	for _, name := range w.order {
+		f := w.families[name]
+		if _, err := fmt.Fprintf(out, "# TYPE %s %s\n# HELP %s %s\n", name, f.typ, name, f.help); err != nil {
+			return err
+		}
+		for labels := 0; labels < f.count; labels++ {
+			series := openMetricsSeries{f.index, labels}
+			for _, b := range buckets {
+				for !b.done && b.series == series {
+					if _, err := fmt.Fprintln(out, b.line); err != nil {
+						return err
+					}
+					if err := b.next(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	_, err := fmt.Fprintln(out, "# EOF")
+	return err
+}
+
+// Release removes the bucket files.
+func (w *openMetricsWriter) Release() error {
+	if w.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(w.dir)
+}
+
+func (s openMetricsSeries) less(other openMetricsSeries) bool {
+	if s.family != other.family {
+		return s.family < other.family
+	}
+	return s.labels < other.labels
+}
+
+// openMetricsBucket reads a bucket file line by line.
+type openMetricsBucket struct {
+	r      *bufio.Reader
+	series openMetricsSeries
+	line   string
+	done   bool
+}
+
+func (b *openMetricsBucket) next() error {
+	line, err := b.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		b.done = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid line: %s", line)
+	}
+	if b.series.family, err = strconv.Atoi(parts[0]); err != nil {
+		return err
+	}
+	if b.series.labels, err = strconv.Atoi(parts[1]); err != nil {
+		return err
+	}
+	b.line = parts[2]
+	return nil
+}
+
+// csvWriter writes samples as rows: timestamp, metric, labels, value.
+type csvWriter struct {
+	headerWritten bool
+}
+
+func newCSVWriter() *csvWriter {
+	return &csvWriter{}
+}
+
+func (w *csvWriter) Write(out io.Writer, t time.Time, families []*dto.MetricFamily) error {
+	cw := csv.NewWriter(out)
+	if !w.headerWritten {
+		if err := cw.Write([]string{"timestamp", "metric", "labels", "value"}); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	ts := strconv.FormatInt(t.Unix(), 10)
+	for _, f := range families {
+		if !strings.HasPrefix(f.GetName(), replayMetricsPrefix) {
+			continue
+		}
+		for _, s := range familySamples(f) {
+			if err := cw.Write([]string{ts, s.Name, formatLabels(s.Labels), formatFloat(s.Value)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (w *csvWriter) Close(io.Writer) error {
+	return nil
+}
+
+func (w *csvWriter) Release() error {
+	return nil
+}
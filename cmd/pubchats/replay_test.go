@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestOpenMetricsWriter(t *testing.T) {
+	gauge := func(chats ...string) []*dto.MetricFamily {
+		f := &dto.MetricFamily{
+			Name: proto.String("shh_test"),
+			Help: proto.String("Test gauge."),
+			Type: dto.MetricType_GAUGE.Enum(),
+		}
+		for i, chat := range chats {
+			f.Metric = append(f.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{{Name: proto.String("chat"), Value: proto.String(chat)}},
+				Gauge: &dto.Gauge{Value: proto.Float64(float64(i + 1))},
+			})
+		}
+		return []*dto.MetricFamily{f, {Name: proto.String("go_goroutines"), Type: dto.MetricType_GAUGE.Enum()}}
+	}
+
+	start := time.Unix(3600, 0)
+	for _, tc := range []struct {
+		name   string
+		bucket time.Duration
+	}{
+		{"single bucket", time.Hour},
+		{"bucket per sample", time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newOpenMetricsWriter(tc.bucket)
+			defer w.Release()
+
+			var out bytes.Buffer
+			samples := [][]*dto.MetricFamily{gauge("status"), gauge("status", "dev"), gauge("dev", "status")}
+			for i, families := range samples {
+				if err := w.Write(&out, start.Add(time.Duration(i)*time.Minute), families); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(&out); err != nil {
+				t.Fatal(err)
+			}
+
+			want := `# TYPE shh_test gauge
+# HELP shh_test Test gauge.
+shh_test{chat="status"} 1 3600
+shh_test{chat="status"} 1 3660
+shh_test{chat="status"} 2 3720
+shh_test{chat="dev"} 2 3660
+shh_test{chat="dev"} 1 3720
+# EOF
+`
+			if got := out.String(); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
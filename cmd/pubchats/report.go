@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const reportTimeFormat = "2006-01-02 15:04 MST"
+
+var reportFuncs = map[string]interface{}{
+	"time": func(t time.Time) string { return t.UTC().Format(reportTimeFormat) },
+	"bar":  func(n, max int) string { return strings.Repeat("#", scale(n, max, 40)) },
+	"inc":  func(i int) int { return i + 1 },
+}
+
+var markdownReportTemplate = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(
+	`# #{{.Channel}} digest ({{.Fleet}})
+
+{{time .Start}} – {{time .End}}
+
+* Messages: {{.Total}} (previous period: {{.PreviousTotal}})
+* Peak: {{if .Peak.Messages}}{{time .Peak.Hour}} with {{.Peak.Messages}} messages{{else}}none{{end}}
+* New authors: {{len .NewAuthors}}
+
+## Volume per hour
+
+| Hour (UTC) | Messages | |
+|---|---:|---|
+{{range .Hours}}| {{time .Hour}} | {{.Messages}} | {{bar .Messages $.Peak.Messages}} |
+{{end}}
+## Top authors
+
+{{range $i, $a := .TopAuthors}}{{inc $i}}. {{$a.Alias}} – {{$a.Messages}}
+{{else}}No messages.
+{{end}}
+## New authors
+
+{{range .NewAuthors}}* {{.}}
+{{else}}None.
+{{end}}
+## Anomalies
+
+{{range .Anomalies}}* {{.}}
+{{else}}None.
+{{end}}`))
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(
+	`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>#{{.Channel}} digest ({{.Fleet}})</title></head>
+<body>
+<h1>#{{.Channel}} digest ({{.Fleet}})</h1>
+<p>{{time .Start}} – {{time .End}}</p>
+<ul>
+<li>Messages: {{.Total}} (previous period: {{.PreviousTotal}})</li>
+<li>Peak: {{if .Peak.Messages}}{{time .Peak.Hour}} with {{.Peak.Messages}} messages{{else}}none{{end}}</li>
+<li>New authors: {{len .NewAuthors}}</li>
+</ul>
+<h2>Volume per hour</h2>
+<table>
+<tr><th>Hour (UTC)</th><th>Messages</th><th></th></tr>
+{{range .Hours}}<tr><td>{{time .Hour}}</td><td>{{.Messages}}</td><td><code>{{bar .Messages $.Peak.Messages}}</code></td></tr>
+{{end}}</table>
+<h2>Top authors</h2>
+<ol>
+{{range .TopAuthors}}<li>{{.Alias}} – {{.Messages}}</li>
+{{end}}</ol>
+<h2>New authors</h2>
+<ul>
+{{range .NewAuthors}}<li>{{.}}</li>
+{{end}}</ul>
+<h2>Anomalies</h2>
+<ul>
+{{range .Anomalies}}<li>{{.}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// runReports flushes the collector at the end of every period
+// and writes the reports until done is closed.
+func runReports(c *digestCollector, channels []channelKey, interval time.Duration, dir, webhook string, done <-chan struct{}) {
+	for {
+		now := time.Now()
+		end := now.Truncate(interval).Add(interval)
+
+		select {
+		case <-time.After(end.Sub(now)):
+		case <-done:
+			return
+		}
+
+		reports, err := c.Flush(channels, end)
+		if err != nil {
+			log.Printf("failed to save digest state: %v", err)
+		}
+
+		for _, r := range reports {
+			if err := publishReport(r, dir, webhook); err != nil {
+				log.Printf("failed to publish a report for channel '%s' of fleet %s: %v", r.Channel, r.Fleet, err)
+			}
+		}
+	}
+}
+
+func publishReport(r *channelReport, dir, webhook string) error {
+	var markdown, html bytes.Buffer
+	if err := markdownReportTemplate.Execute(&markdown, r); err != nil {
+		return err
+	}
+	if err := htmlReportTemplate.Execute(&html, r); err != nil {
+		return err
+	}
+
+	channelDir := archiveChannelDir(dir, channelKey{Fleet: r.Fleet, Chat: r.Channel})
+	if err := os.MkdirAll(channelDir, 0750); err != nil {
+		return err
+	}
+	name := r.Start.UTC().Format("2006-01-02T15-04")
+	if err := ioutil.WriteFile(filepath.Join(channelDir, name+".md"), markdown.Bytes(), 0640); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(channelDir, name+".html"), html.Bytes(), 0640); err != nil {
+		return err
+	}
+	log.Printf("written a report for channel '%s' of fleet %s to %s", r.Channel, r.Fleet, channelDir)
+
+	if webhook == "" {
+		return nil
+	}
+	return postReport(webhook, markdown.String())
+}
+
+// postReport sends a report in a format accepted by Slack and Mattermost webhooks.
+func postReport(url, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected webhook response status: %s", resp.Status)
+	}
+	return nil
+}
+
+func formatDrop(v hourVolume, avg float64) string {
+	return fmt.Sprintf("volume dropped to %d messages at %s from an average of %.1f",
+		v.Messages, v.Hour.UTC().Format(reportTimeFormat), avg)
+}
+
+func formatPeriodDrop(r *channelReport) string {
+	return fmt.Sprintf("volume dropped to %d messages from %d in the previous period",
+		r.Total, r.PreviousTotal)
+}
+
+func scale(n, max, width int) int {
+	if max == 0 {
+		return 0
+	}
+	return n * width / max
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const archiveExportSuffix = ".jsonl.gz"
+
+// retentionPolicy limits how long and how much of a channel is archived.
+// Zero values mean no limit.
+type retentionPolicy struct {
+	MaxAge  time.Duration
+	MaxSize int64 // bytes
+}
+
+// retentionPolicies holds the default policy and overrides per channel.
+type retentionPolicies struct {
+	Default  retentionPolicy
+	Channels map[string]retentionPolicy
+}
+
+// For returns the policy of the channel.
+func (p retentionPolicies) For(chat string) retentionPolicy {
+	if policy, ok := p.Channels[chat]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// loadRetentionPolicies reads overrides from a JSON file in the form
+// {"<channel>": {"maxAge": "720h", "maxSize": "1GB"}}. Limits which are not
+// set in an override are taken from the default policy.
+func loadRetentionPolicies(path string, defaultPolicy retentionPolicy) (retentionPolicies, error) {
+	policies := retentionPolicies{
+		Default:  defaultPolicy,
+		Channels: make(map[string]retentionPolicy),
+	}
+	if path == "" {
+		return policies, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return policies, err
+	}
+
+	var overrides map[string]struct {
+		MaxAge  string `json:"maxAge"`
+		MaxSize string `json:"maxSize"`
+	}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return policies, err
+	}
+
+	for chat, o := range overrides {
+		policy := defaultPolicy
+		if o.MaxAge != "" {
+			policy.MaxAge, err = time.ParseDuration(o.MaxAge)
+			if err != nil {
+				return policies, fmt.Errorf("channel '%s': invalid max age: %v", chat, err)
+			}
+		}
+		if o.MaxSize != "" {
+			policy.MaxSize, err = parseSize(o.MaxSize)
+			if err != nil {
+				return policies, fmt.Errorf("channel '%s': invalid max size: %v", chat, err)
+			}
+		}
+		policies.Channels[chat] = policy
+	}
+
+	return policies, nil
+}
+
+// parseSize parses a number of bytes with an optional KB, MB or GB suffix,
+// which are multiples of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("size must not be negative")
+	}
+	return n * multiplier, nil
+}
+
+// archiveExporter receives messages before they are deleted from the archive.
+// If it fails, the messages are kept.
+type archiveExporter interface {
+	Export(ch channelKey, day string, messages []*receivedMessage) error
+}
+
+// gzipExporter writes expired messages to a cold archive with the same layout
+// as the archive, but with gzipped segments: <dir>/<channel>/<YYYY-MM-DD>.jsonl.gz.
+// Messages expired from the same day at different times are appended
+// as separate gzip members, which are read as a single stream. Messages
+// which are already in the export, because removing them from the archive
+// failed after they were exported, are skipped.
+type gzipExporter struct {
+	dir string
+}
+
+func (e *gzipExporter) Export(ch channelKey, day string, messages []*receivedMessage) (err error) {
+	dir := archiveChannelDir(e.dir, ch)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, day+archiveExportSuffix)
+
+	exported := make(map[string]struct{})
+	err = readSegment(path, func(m *receivedMessage) error {
+		exported[m.Hash] = struct{}{}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read exported messages: %v", err)
+	}
+	var pending []*receivedMessage
+	for _, m := range messages {
+		if _, ok := exported[m.Hash]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, m := range pending {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// runCompaction applies retention policies to all channels in the archive
+// every interval until done is closed.
+func runCompaction(a *messageArchive, policies retentionPolicies, exporter archiveExporter, interval time.Duration, done <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.CompactAll(policies, exporter, time.Now()); err != nil {
+			log.Printf("failed to compact the archive: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// CompactAll compacts every channel of every fleet found in the archive,
+// including the ones which are not tracked anymore.
+func (a *messageArchive) CompactAll(policies retentionPolicies, exporter archiveExporter, now time.Time) error {
+	channels, err := archiveChannels(a.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range channels {
+		if err := a.Compact(ch, policies.For(ch.Chat), exporter, now); err != nil {
+			log.Printf("failed to compact channel '%s' of fleet %s: %v", ch.Chat, ch.Fleet, err)
+		}
+	}
+
+	return nil
+}
+
+// Compact removes messages older than the maximum age and then the oldest
+// segments until the channel fits in the maximum size. The segment of
+// the current day is never removed because of its size. Removed messages
+// are passed to the exporter first, if it is not nil. Messages can be
+// archived while the channel is compacted.
+func (a *messageArchive) Compact(ch channelKey, p retentionPolicy, exporter archiveExporter, now time.Time) error {
+	if p.MaxAge == 0 && p.MaxSize == 0 {
+		return nil
+	}
+
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+
+	days, err := a.channelSegments(ch)
+	if err != nil {
+		return err
+	}
+
+	var expired int
+	if p.MaxAge > 0 {
+		cutoff := now.Add(-p.MaxAge)
+		kept := days[:0]
+		for _, day := range days {
+			start, err := time.Parse(archiveDayLayout, day)
+			if err != nil {
+				continue
+			}
+
+			var n int
+			switch {
+			case !start.Add(24 * time.Hour).After(cutoff):
+				n, err = a.expireSegment(ch, day, exporter, func(*receivedMessage) bool { return true })
+			case start.Before(cutoff):
+				n, err = a.expireSegment(ch, day, exporter, func(m *receivedMessage) bool {
+					return m.ReceivedAt.Before(cutoff)
+				})
+			}
+			if err != nil {
+				return err
+			}
+			expired += n
+
+			if _, err := os.Stat(a.segmentPath(ch, day)); err == nil {
+				kept = append(kept, day)
+			}
+		}
+		days = kept
+	}
+
+	if p.MaxSize > 0 {
+		var total int64
+		sizes := make(map[string]int64)
+		for _, day := range days {
+			info, err := os.Stat(a.segmentPath(ch, day))
+			if err != nil {
+				return err
+			}
+			sizes[day] = info.Size()
+			total += info.Size()
+		}
+
+		today := now.UTC().Format(archiveDayLayout)
+		for _, day := range days {
+			if total <= p.MaxSize || day == today {
+				break
+			}
+			n, err := a.expireSegment(ch, day, exporter, func(*receivedMessage) bool { return true })
+			if err != nil {
+				return err
+			}
+			expired += n
+			total -= sizes[day]
+		}
+	}
+
+	if expired > 0 {
+		log.Printf("removed %d messages of channel '%s' of fleet %s from the archive", expired, ch.Chat, ch.Fleet)
+	}
+	return nil
+}
+
+// channelSegments returns days of the channel segments in ascending order.
+func (a *messageArchive) channelSegments(ch channelKey) ([]string, error) {
+	entries, err := ioutil.ReadDir(archiveChannelDir(a.dir, ch))
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), archiveSegmentSuffix) {
+			continue
+		}
+		days = append(days, strings.TrimSuffix(e.Name(), archiveSegmentSuffix))
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func (a *messageArchive) segmentPath(ch channelKey, day string) string {
+	return filepath.Join(archiveChannelDir(a.dir, ch), day+archiveSegmentSuffix)
+}
+
+// expireSegment removes messages for which expired returns true from the segment,
+// and the segment itself if no messages are left. It returns the number of
+// removed messages. The archive lock is held only to take a snapshot of
+// the segment size and to replace the segment, so messages appended after
+// the snapshot are copied to the new segment as they are.
+func (a *messageArchive) expireSegment(ch channelKey, day string, exporter archiveExporter, expired func(*receivedMessage) bool) (int, error) {
+	path := a.segmentPath(ch, day)
+
+	a.mu.Lock()
+	info, err := os.Stat(path)
+	a.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	var removed, kept []*receivedMessage
+	err = readSegmentPrefix(path, size, func(m *receivedMessage) error {
+		if expired(m) {
+			removed = append(removed, m)
+		} else {
+			kept = append(kept, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	if exporter != nil {
+		if err := exporter.Export(ch, day, removed); err != nil {
+			return 0, fmt.Errorf("failed to export messages: %v", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := writeSegment(tmp, kept); err != nil {
+		return 0, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// The segment is reopened on the next message.
+	if s, ok := a.segments[ch]; ok && s.day == day {
+		_ = s.file.Close()
+		delete(a.segments, ch)
+	}
+
+	n, err := copySegmentTail(tmp, path, size)
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if len(kept) == 0 && n == 0 {
+		os.Remove(tmp)
+		return len(removed), os.Remove(path)
+	}
+	return len(removed), os.Rename(tmp, path)
+}
+
+// readSegmentPrefix calls fn for every message in the first size bytes of the segment.
+func readSegmentPrefix(path string, size int64, fn func(*receivedMessage) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return decodeMessages(io.LimitReader(f, size), path, fn)
+}
+
+// copySegmentTail appends the segment from the offset to dst
+// and returns the number of copied bytes.
+func copySegmentTail(dst, path string, offset int64) (int64, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(dst, os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, src)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// writeSegment writes messages to a new segment file.
+func writeSegment(path string, messages []*receivedMessage) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, m := range messages {
+		if err := enc.Encode(m); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
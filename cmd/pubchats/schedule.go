@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule returns the next activation time after the given time.
+type schedule interface {
+	Next(time.Time) time.Time
+}
+
+// intervalSchedule activates every fixed period of time.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronSchedule is a subset of the cron format.
+// It consists of five fields: minute, hour, day of month, month and day of week.
+// Each field accepts "*", numbers, ranges "a-b", lists "a,b" and steps "*/n" or "a-b/n".
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit sets of allowed values
+
+	// As in cron, if both day fields are restricted,
+	// a day matches when either of them matches.
+	domStar, dowStar bool
+}
+
+// maxCronLookahead limits searching for the next activation time,
+// for example, for schedules like "0 0 31 2 *" which never activate.
+// It covers the longest gap between leap days, from 2096 to 2104.
+const maxCronLookahead = 8 * 366 * 24 * time.Hour
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron spec '%s', got %d", spec, len(fields))
+	}
+
+	var (
+		s   cronSchedule
+		err error
+	)
+
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute: %v", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour: %v", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month: %v", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month: %v", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week: %v", err)
+	}
+	// Sunday can be both 0 and 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangeSpec, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangeSpec = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+		}
+
+		var from, to int
+		switch {
+		case rangeSpec == "*":
+			from, to = min, max
+		case strings.Contains(rangeSpec, "-"):
+			bounds := strings.SplitN(rangeSpec, "-", 2)
+			var err1, err2 error
+			from, err1 = strconv.Atoi(bounds[0])
+			to, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range '%s'", rangeSpec)
+			}
+		default:
+			v, err := strconv.Atoi(rangeSpec)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", rangeSpec)
+			}
+			from, to = v, v
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("'%s' out of range [%d, %d]", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	if bits == 0 {
+		return 0, errors.New("empty field")
+	}
+
+	return bits, nil
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	deadline := t.Add(maxCronLookahead)
+
+	for t.Before(deadline) {
+		if !s.matchDay(t) || s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Truncate works in UTC, which is off for zones with half-hour offsets.
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
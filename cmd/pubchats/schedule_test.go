@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	india := time.FixedZone("IST", 5*3600+30*60)
+	date := func(year int, month time.Month, day, hour, min int, loc *time.Location) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	for _, tc := range []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", date(2019, 11, 4, 10, 15, time.UTC), date(2019, 11, 4, 10, 16, time.UTC)},
+		{"seconds are dropped", "* * * * *", time.Date(2019, 11, 4, 10, 15, 30, 0, time.UTC), date(2019, 11, 4, 10, 16, time.UTC)},
+		{"later this hour", "30 * * * *", date(2019, 11, 4, 10, 15, time.UTC), date(2019, 11, 4, 10, 30, time.UTC)},
+		{"next hour", "0 * * * *", date(2019, 11, 4, 10, 15, time.UTC), date(2019, 11, 4, 11, 0, time.UTC)},
+		{"next day", "0 9 * * *", date(2019, 11, 4, 10, 15, time.UTC), date(2019, 11, 5, 9, 0, time.UTC)},
+		{"next month", "0 0 1 * *", date(2019, 11, 4, 10, 15, time.UTC), date(2019, 12, 1, 0, 0, time.UTC)},
+		{"next year", "0 0 1 1 *", date(2019, 11, 4, 10, 15, time.UTC), date(2020, 1, 1, 0, 0, time.UTC)},
+		{"step", "*/20 * * * *", date(2019, 11, 4, 10, 45, time.UTC), date(2019, 11, 4, 11, 0, time.UTC)},
+		{"list and range", "0 8-10,18 * * *", date(2019, 11, 4, 10, 15, time.UTC), date(2019, 11, 4, 18, 0, time.UTC)},
+		{"day of week", "0 12 * * 1", date(2019, 11, 5, 10, 0, time.UTC), date(2019, 11, 11, 12, 0, time.UTC)},
+		{"sunday as 7", "0 12 * * 7", date(2019, 11, 4, 10, 0, time.UTC), date(2019, 11, 10, 12, 0, time.UTC)},
+		{"either day field", "0 0 15 * 1", date(2019, 11, 12, 10, 0, time.UTC), date(2019, 11, 15, 0, 0, time.UTC)},
+		{"half-hour offset", "0 * * * *", date(2019, 11, 4, 10, 15, india), date(2019, 11, 4, 11, 0, india)},
+		{"half-hour offset hour", "0 9 * * *", date(2019, 11, 4, 8, 45, india), date(2019, 11, 4, 9, 0, india)},
+		{"leap day", "0 0 29 2 *", date(2019, 3, 1, 0, 0, time.UTC), date(2020, 2, 29, 0, 0, time.UTC)},
+		{"leap day after a skipped leap year", "0 0 29 2 *", date(2096, 3, 1, 0, 0, time.UTC), date(2104, 2, 29, 0, 0, time.UTC)},
+		{"never", "0 0 31 2 *", date(2019, 11, 4, 10, 15, time.UTC), time.Time{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := parseCron(tc.spec)
+			if err != nil {
+				t.Fatalf("failed to parse '%s': %v", tc.spec, err)
+			}
+			if got := s.Next(tc.from); !got.Equal(tc.want) {
+				t.Errorf("Next(%s) of '%s' = %s, want %s", tc.from, tc.spec, got, tc.want)
+			}
+		})
+	}
+}
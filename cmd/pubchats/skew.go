@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// Sources of clock skew measurements used as metric labels.
+// Values are differences between the first and the second clock.
+const (
+	skewSourceMessage         = "message"          // message timestamp vs receive time
+	skewSourceEnvelope        = "envelope"         // envelope timestamp vs receive time
+	skewSourceLamport         = "lamport"          // Lamport clock vs receive time
+	skewSourceMessageEnvelope = "message_envelope" // message timestamp vs envelope timestamp
+	skewSourceLamportMessage  = "lamport_message"  // Lamport clock vs message timestamp
+)
+
+// skewHandler compares clocks reported by senders with each other
+// and with the receive time, and detects authors with skewed clocks.
+type skewHandler struct {
+	threshold time.Duration
+
+	// mapping channel => authors with skewed clocks
+	skewedAuthors map[channelKey]map[string]struct{}
+}
+
+func newSkewHandler(threshold time.Duration) *skewHandler {
+	return &skewHandler{
+		threshold:     threshold,
+		skewedAuthors: make(map[channelKey]map[string]struct{}),
+	}
+}
+
+func (h *skewHandler) HandleMessage(m *receivedMessage) {
+	received := m.ReceivedAt
+	envelope := time.Unix(int64(m.Timestamp), 0)
+
+	if m.Timestamp != 0 {
+		h.observe(m.channel(), skewSourceEnvelope, envelope.Sub(received))
+	}
+
+	payload := m.Decoded()
+	if payload.Timestamp == 0 {
+		return
+	}
+
+	// Status clients use milliseconds for both the timestamp and the Lamport clock,
+	// which is bumped to the sender's time whenever it falls behind.
+	timestamp := msToTime(payload.Timestamp)
+	lamport := msToTime(payload.Clock)
+
+	skew := timestamp.Sub(received)
+	h.observe(m.channel(), skewSourceMessage, skew)
+	h.observe(m.channel(), skewSourceLamport, lamport.Sub(received))
+	h.observe(m.channel(), skewSourceLamportMessage, lamport.Sub(timestamp))
+	if m.Timestamp != 0 {
+		h.observe(m.channel(), skewSourceMessageEnvelope, timestamp.Sub(envelope))
+	}
+
+	h.updateAuthor(m.channel(), m.Author, skew)
+}
+
+func (h *skewHandler) observe(ch channelKey, source string, skew time.Duration) {
+	clockSkewHistogram.WithLabelValues(ch.Fleet, ch.Chat, source).Observe(skew.Seconds())
+}
+
+// updateAuthor tracks authors whose last message exceeded the threshold.
+func (h *skewHandler) updateAuthor(ch channelKey, author string, skew time.Duration) {
+	authors, ok := h.skewedAuthors[ch]
+	if !ok {
+		authors = make(map[string]struct{})
+		h.skewedAuthors[ch] = authors
+	}
+
+	_, wasSkewed := authors[author]
+	isSkewed := skew > h.threshold || skew < -h.threshold
+
+	switch {
+	case isSkewed && !wasSkewed:
+		skewedAuthorsGauge.WithLabelValues(ch.Fleet, ch.Chat).Inc()
+		log.Printf("author %s (%s) in channel '%s' of fleet %s has a clock skewed by %s", author, authorAlias(author), ch.Chat, ch.Fleet, skew)
+	case !isSkewed && wasSkewed:
+		skewedAuthorsGauge.WithLabelValues(ch.Fleet, ch.Chat).Dec()
+		log.Printf("author %s (%s) in channel '%s' of fleet %s has a clock in sync again", author, authorAlias(author), ch.Chat, ch.Fleet)
+	}
+
+	if isSkewed {
+		authors[author] = struct{}{}
+		authorSkewGauge.Set(skew.Seconds(), ch.Fleet, ch.Chat, author)
+	} else if wasSkewed {
+		delete(authors, author)
+		authorSkewGauge.DeleteLabelValues(ch.Fleet, ch.Chat, author)
+	}
+}
+
+// maxTimeMs is the latest time in milliseconds which fits in time.Time
+// as nanoseconds since the epoch.
+const maxTimeMs = math.MaxInt64 / int64(time.Millisecond)
+
+// msToTime converts milliseconds since the epoch, clamping values
+// too large to be represented, which senders may use as well.
+func msToTime(ms uint64) time.Time {
+	if ms > uint64(maxTimeMs) {
+		ms = uint64(maxTimeMs)
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/status-im/whisper/shhclient"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+const (
+	subscriptionMinBackoff = time.Second
+	subscriptionMaxBackoff = 5 * time.Minute
+	// A subscription which stayed active this long resets the backoff.
+	subscriptionHealthyTime = time.Minute
+)
+
+// States of a channel subscription exported by subscriptionStateGauge.
+const (
+	subscriptionConnecting = "connecting"
+	subscriptionActive     = "active"
+	subscriptionBackoff    = "backoff"
+)
+
+var subscriptionStates = []string{subscriptionConnecting, subscriptionActive, subscriptionBackoff}
+
+// superviseChannel keeps a subscription to the channel alive until done is closed.
+// When the subscription fails, its symmetric key and the subscription
+// are re-created with exponential backoff, which starts over only if the
+// subscription was healthy for a while. Other channels are not affected.
+// The node is the fleet node a vantage point is pinned to, and empty
+// for nodes connected to the whole fleet.
+func superviseChannel(shh *shhclient.Client, fleet, node, chat string, messages chan<- *whisper.Message, done <-chan struct{}) {
+	backoff := subscriptionMinBackoff
+	source := subscriptionSource(fleet, node)
+
+	for {
+		setSubscriptionState(fleet, node, chat, subscriptionConnecting)
+
+		active, err := runSubscription(shh, fleet, node, chat, messages, done)
+		if err == nil {
+			return
+		}
+		if active >= subscriptionHealthyTime {
+			backoff = subscriptionMinBackoff
+		}
+
+		log.Printf("subscription to channel '%s' in %s failed, retrying in %s: %v", chat, source, backoff, err)
+		setSubscriptionState(fleet, node, chat, subscriptionBackoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-done:
+			return
+		}
+
+		subscriptionReconnectsCounter.WithLabelValues(fleet, node, chat).Inc()
+
+		backoff *= 2
+		if backoff > subscriptionMaxBackoff {
+			backoff = subscriptionMaxBackoff
+		}
+	}
+}
+
+// runSubscription subscribes to the channel and blocks until the subscription
+// fails or done is closed. It returns a nil error only in the latter case,
+// and how long the subscription was active.
+func runSubscription(shh *shhclient.Client, fleet, node, chat string, messages chan<- *whisper.Message, done <-chan struct{}) (active time.Duration, err error) {
+	symKeyID, err := addPublicChatSymKey(shh, chat)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add sym key: %v", err)
+	}
+	defer deleteSymKey(shh, symKeyID)
+
+	sub, err := subscribeMessages(shh, chat, symKeyID, messages)
+	if err != nil {
+		return 0, fmt.Errorf("failed to subscribe to messages: %v", err)
+	}
+	defer sub.Unsubscribe()
+	subscribed := time.Now()
+
+	setSubscriptionState(fleet, node, chat, subscriptionActive)
+	log.Printf("subscribed to channel '%s' in %s", chat, subscriptionSource(fleet, node))
+
+	select {
+	case err := <-sub.Err():
+		if err == nil {
+			err = errors.New("subscription closed")
+		}
+		return time.Since(subscribed), err
+	case <-done:
+		return time.Since(subscribed), nil
+	}
+}
+
+func deleteSymKey(shh *shhclient.Client, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shh.DeleteSymmetricKey(ctx, id); err != nil {
+		log.Printf("failed to delete sym key %s: %v", id, err)
+	}
+}
+
+func setSubscriptionState(fleet, node, chat, state string) {
+	for _, s := range subscriptionStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		subscriptionStateGauge.WithLabelValues(fleet, node, chat, s).Set(value)
+	}
+}
+
+// subscriptionSource describes where messages of a subscription come from in logs.
+func subscriptionSource(fleet, node string) string {
+	if node == "" {
+		return "fleet " + fleet
+	}
+	return fmt.Sprintf("fleet %s through node %s", fleet, node)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const transcriptDayLayout = "2006-01-02"
+
+// Archive segments are days of the receive time, which differs from the time
+// a message was sent by delivery delays and clock skew. Segments this much
+// outside of the requested range are not read.
+const transcriptSegmentMargin = 24 * time.Hour
+
+// transcriptEntry is a message as it appears in a transcript.
+type transcriptEntry struct {
+	Chat        string    `json:"chat"`
+	Time        time.Time `json:"time"`
+	Author      string    `json:"author"`
+	Alias       string    `json:"alias"`
+	ContentType string    `json:"contentType"`
+	Text        string    `json:"text,omitempty"`
+	Hash        string    `json:"hash"`
+}
+
+// runTranscript exports messages of the tracked channels from a time range,
+// either from the archive or fetched from a mail server.
+func runTranscript() error {
+	fleet := mustParseSingleFleet("transcript")
+	if len(fleet.Channels) == 0 {
+		return errors.New("no channels given")
+	}
+
+	now := time.Now()
+	from, err := parseTranscriptTime(*transcriptFrom, now.Add(-24*time.Hour))
+	if err != nil {
+		return fmt.Errorf("invalid start time: %v", err)
+	}
+	to, err := parseTranscriptTime(*transcriptTo, now)
+	if err != nil {
+		return fmt.Errorf("invalid end time: %v", err)
+	}
+	if !from.Before(to) {
+		return errors.New("start time must be before end time")
+	}
+
+	var write func(io.Writer, []transcriptEntry) error
+	switch *transcriptFormat {
+	case "jsonl":
+		write = writeTranscriptJSONL
+	case "csv":
+		write = writeTranscriptCSV
+	case "markdown":
+		write = writeTranscriptMarkdown
+	default:
+		return fmt.Errorf("unknown format '%s'", *transcriptFormat)
+	}
+
+	var messages []*receivedMessage
+	switch *transcriptSource {
+	case "archive":
+		if *archiveDir == "" {
+			return errors.New("--archive-dir is required to export from the archive")
+		}
+		// Messages exported from the archive are included too.
+		dirs := []string{*archiveDir}
+		if *archiveExportDir != "" {
+			dirs = append(dirs, *archiveExportDir)
+		}
+		messages, err = archivedMessages(dirs, fleet.Name, fleet.Channels, from, to)
+	case "mailserver":
+		messages, err = fetchMessages(fleet, *transcriptServer, from, to)
+	default:
+		return fmt.Errorf("unknown source '%s'", *transcriptSource)
+	}
+	if err != nil {
+		return err
+	}
+
+	entries := newTranscript(messages, from, to)
+	log.Printf("exporting %d messages from %d channels", len(entries), len(fleet.Channels))
+
+	out := os.Stdout
+	if *transcriptOutput != "" && *transcriptOutput != "-" {
+		f, err := os.Create(*transcriptOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return write(out, entries)
+}
+
+// parseTranscriptTime parses an RFC 3339 time or a date in UTC.
+func parseTranscriptTime(s string, defaultTime time.Time) (time.Time, error) {
+	if s == "" {
+		return defaultTime, nil
+	}
+	if t, err := time.Parse(transcriptDayLayout, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// archivedMessages reads messages of the fleet channels from archive
+// directories. Only segments of days around the time range are read.
+func archivedMessages(dirs []string, fleet string, channels []string, from, to time.Time) ([]*receivedMessage, error) {
+	first := from.Add(-transcriptSegmentMargin).UTC().Format(archiveDayLayout)
+	last := to.Add(transcriptSegmentMargin).UTC().Format(archiveDayLayout)
+
+	var messages []*receivedMessage
+	for _, dir := range dirs {
+		for _, chat := range channels {
+			path := archiveChannelDir(dir, channelKey{Fleet: fleet, Chat: chat})
+			entries, err := ioutil.ReadDir(path)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			for _, e := range entries {
+				// Days are formatted so that they are ordered as strings.
+				day, ok := archiveSegmentDay(e)
+				if !ok || day < first || day > last {
+					continue
+				}
+				err := readSegment(filepath.Join(path, e.Name()), func(m *receivedMessage) error {
+					messages = append(messages, m)
+					return nil
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return messages, nil
+}
+
+// newTranscript returns messages sent within [from, to), ordered by channel
+// and time. Messages received more than once are included once.
+func newTranscript(messages []*receivedMessage, from, to time.Time) []transcriptEntry {
+	seen := make(map[string]struct{})
+	var entries []transcriptEntry
+
+	for _, m := range messages {
+		if _, ok := seen[m.Hash]; ok {
+			continue
+		}
+		seen[m.Hash] = struct{}{}
+
+		sent := messageSentAt(m)
+		if sent.Before(from) || !sent.Before(to) {
+			continue
+		}
+
+		payload := m.Decoded()
+		entries = append(entries, transcriptEntry{
+			Chat:        m.Chat,
+			Time:        sent.UTC(),
+			Author:      m.Author,
+			Alias:       authorAlias(m.Author),
+			ContentType: payload.ContentType,
+			Text:        payload.Text,
+			Hash:        m.Hash,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Chat != entries[j].Chat {
+			return entries[i].Chat < entries[j].Chat
+		}
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries
+}
+
+// messageSentAt returns the time the sender reported, falling back
+// to the envelope timestamp and the receive time.
+func messageSentAt(m *receivedMessage) time.Time {
+	if ts := m.Decoded().Timestamp; ts != 0 {
+		return msToTime(ts)
+	}
+	if m.Timestamp != 0 {
+		return time.Unix(int64(m.Timestamp), 0)
+	}
+	return m.ReceivedAt
+}
+
+func writeTranscriptJSONL(w io.Writer, entries []transcriptEntry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTranscriptCSV(w io.Writer, entries []transcriptEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"chat", "time", "author", "alias", "content_type", "text", "hash"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{e.Chat, e.Time.Format(time.RFC3339), e.Author, e.Alias, e.ContentType, e.Text, e.Hash}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTranscriptMarkdown writes a section per channel and day.
+// Messages without text are shown by their content type.
+func writeTranscriptMarkdown(w io.Writer, entries []transcriptEntry) error {
+	var chat, day string
+	for _, e := range entries {
+		if e.Chat != chat {
+			heading := fmt.Sprintf("# #%s\n", e.Chat)
+			if chat != "" {
+				heading = "\n" + heading
+			}
+			chat, day = e.Chat, ""
+			if _, err := io.WriteString(w, heading); err != nil {
+				return err
+			}
+		}
+		if d := e.Time.Format(transcriptDayLayout); d != day {
+			day = d
+			if _, err := fmt.Fprintf(w, "\n## %s\n\n", day); err != nil {
+				return err
+			}
+		}
+
+		text := e.Text
+		if text == "" {
+			text = fmt.Sprintf("_[%s]_", e.ContentType)
+		}
+		// Keep every message in a single list item.
+		text = strings.Replace(text, "\n", "\n  ", -1)
+
+		if _, err := fmt.Fprintf(w, "- `%s` **%s**: %s\n", e.Time.Format("15:04:05"), e.Alias, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,13 +8,14 @@ import (
 )
 
 var (
-	fleet       = pflag.StringP("fleet", "f", params.FleetProd, "cluster fleet")
-	datadir     = pflag.StringP("datadir", "d", "", "home directory for node data")
-	privkey     = pflag.StringP("privkey", "p", "", "private key for connecting to nodes, hexadecimal")
-	mailservers = pflag.StringArrayP("mailservers", "m", nil, "a list of mail servers")
-	duration    = pflag.DurationP("duration", "l", time.Hour*24, "length of time span from now")
-	channels    = pflag.StringArrayP("channels", "c", []string{"status"}, "name of one or more channels")
-	verbosity   = pflag.StringP("verbosity", "v", "INFO", "verbosity level of status-go, options: crit, error, warn, info, debug")
+	fleet        = pflag.StringP("fleet", "f", params.FleetProd, "cluster fleet")
+	datadir      = pflag.StringP("datadir", "d", "", "home directory for node data")
+	privkey      = pflag.StringP("privkey", "p", "", "private key for connecting to nodes, hexadecimal")
+	mailservers  = pflag.StringArrayP("mailservers", "m", nil, "a list of mail servers")
+	duration     = pflag.DurationP("duration", "l", time.Hour*24, "length of time span from now")
+	channels     = pflag.StringArrayP("channels", "c", []string{"status"}, "name of one or more channels")
+	verbosity    = pflag.StringP("verbosity", "v", "INFO", "verbosity level of status-go, options: crit, error, warn, info, debug")
+	metricsSinks = pflag.StringArray("metrics-sink", nil, "metrics sink for the results, e.g. pushgateway://host:9091, statsd://host:8125 or otlp://host:4318")
 )
 
 func init() {
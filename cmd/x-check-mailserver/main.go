@@ -39,8 +39,11 @@ func main() {
 	signals := make(chan os.Signal, 1)
 	stdsignal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
+	sinks := startMetricsSinks()
+
 	go func() {
 		<-signals
+		closeMetricsSinks(sinks)
 		os.Exit(1)
 	}()
 
@@ -106,6 +109,11 @@ func main() {
 	exitCode := 0
 	failedMailServers := make([]string, 0)
 
+	for _, work := range workUnites {
+		messagesGauge.WithLabelValues(mailServerLabel(work.MailServerEnode)).Set(float64(len(work.MessageHashes)))
+		failedGauge.WithLabelValues(mailServerLabel(work.MailServerEnode)).Set(0)
+	}
+
 	for i, j := 0, 1; j < len(workUnites); j++ {
 		workA := workUnites[i]
 		workB := workUnites[j]
@@ -113,6 +121,7 @@ func main() {
 
 		if !areEqual {
 			failedMailServers = append(failedMailServers, workB.MailServerEnode)
+			failedGauge.WithLabelValues(mailServerLabel(workB.MailServerEnode)).Set(1)
 			exitCode = 1
 		}
 
@@ -127,5 +136,6 @@ func main() {
 		log.Error("the following mail servers failed to return all messages", "enodes", failedMailServers)
 	}
 
+	closeMetricsSinks(sinks)
 	os.Exit(exitCode)
 }
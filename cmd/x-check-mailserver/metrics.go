@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/status-im/statusd-bots/metrics"
+)
+
+var (
+	messagesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "xcheck_mailserver",
+		Name:      "messages",
+		Help:      "Number of messages returned by a mail server.",
+	}, []string{"mailserver"})
+	failedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "xcheck_mailserver",
+		Name:      "failed",
+		Help:      "Set to 1 if a mail server returned fewer messages than the best one.",
+	}, []string{"mailserver"})
+)
+
+func init() {
+	prometheus.MustRegister(messagesGauge)
+	prometheus.MustRegister(failedGauge)
+}
+
+func startMetricsSinks() metrics.Sinks {
+	sinks, err := metrics.StartSinks(*metricsSinks, "x-check-mailserver", prometheus.DefaultGatherer)
+	if err != nil {
+		log.Crit("failed to start metrics sinks", "err", err)
+	}
+	return sinks
+}
+
+// closeMetricsSinks pushes the results for the last time before the process exits.
+func closeMetricsSinks(sinks metrics.Sinks) {
+	if err := sinks.Close(); err != nil {
+		log.Error("failed to close metrics sinks", "err", err)
+	}
+}
+
+// mailServerLabel returns a short name of a mail server used as a metric label.
+func mailServerLabel(msEnode string) string {
+	n, err := enode.ParseV4(msEnode)
+	if err != nil {
+		return msEnode
+	}
+	return fmt.Sprintf("%s:%d", n.IP(), n.TCP())
+}
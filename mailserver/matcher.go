@@ -0,0 +1,88 @@
+package mailserver
+
+import "time"
+
+// Match tells how a response relates to the requests of a Matcher.
+type Match int
+
+const (
+	// Matched responses belong to a pending request, which is finished.
+	Matched Match = iota
+	// Early responses belong to no known request yet. A response can arrive
+	// before the hash of its request is known, so it is kept until the request
+	// is sent or the response expires.
+	Early
+	// Duplicate responses belong to a finished request, for example
+	// an expiration signal after a completed request.
+	Duplicate
+)
+
+// Matcher matches responses with sent requests by their hashes.
+// It is not safe for concurrent use.
+type Matcher struct {
+	pending  map[string]struct{}
+	early    map[string]Response
+	finished map[string]struct{}
+}
+
+// NewMatcher returns a Matcher without requests.
+func NewMatcher() *Matcher {
+	return &Matcher{
+		pending:  make(map[string]struct{}),
+		early:    make(map[string]Response),
+		finished: make(map[string]struct{}),
+	}
+}
+
+// Sent adds a pending request. If its response has already arrived,
+// the request is finished and the response is returned.
+func (m *Matcher) Sent(hash string) (Response, bool) {
+	hash = NormalizeHash(hash)
+	if r, ok := m.early[hash]; ok {
+		delete(m.early, hash)
+		m.finished[hash] = struct{}{}
+		return r, true
+	}
+	m.pending[hash] = struct{}{}
+	return Response{}, false
+}
+
+// Match finishes the pending request of the response.
+func (m *Matcher) Match(r Response) Match {
+	if _, ok := m.pending[r.RequestHash]; ok {
+		delete(m.pending, r.RequestHash)
+		m.finished[r.RequestHash] = struct{}{}
+		return Matched
+	}
+	if _, ok := m.finished[r.RequestHash]; ok {
+		return Duplicate
+	}
+	m.early[r.RequestHash] = r
+	return Early
+}
+
+// Finish finishes a pending request without a response, for example
+// when it times out, so that a late response is a duplicate.
+func (m *Matcher) Finish(hash string) {
+	hash = NormalizeHash(hash)
+	delete(m.pending, hash)
+	m.finished[hash] = struct{}{}
+}
+
+// ExpireEarly forgets early responses received before the time
+// and returns them.
+func (m *Matcher) ExpireEarly(before time.Time) []Response {
+	var expired []Response
+	for hash, r := range m.early {
+		if r.ReceivedAt.Before(before) {
+			delete(m.early, hash)
+			expired = append(expired, r)
+		}
+	}
+	return expired
+}
+
+// Early returns the number of early responses.
+func (m *Matcher) Early() int {
+	return len(m.early)
+}
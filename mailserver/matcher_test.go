@@ -0,0 +1,46 @@
+package mailserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatcher(t *testing.T) {
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMatcher()
+
+	if _, ok := m.Sent("0xAA"); ok {
+		t.Fatalf("request without a response returned one")
+	}
+	if got := m.Match(Response{RequestHash: "aa", ReceivedAt: at}); got != Matched {
+		t.Errorf("response of a pending request: got %v, want %v", got, Matched)
+	}
+	if got := m.Match(Response{RequestHash: "aa", ReceivedAt: at}); got != Duplicate {
+		t.Errorf("second response of a request: got %v, want %v", got, Duplicate)
+	}
+
+	if got := m.Match(Response{RequestHash: "bb", Cursor: "next", ReceivedAt: at}); got != Early {
+		t.Errorf("response of an unknown request: got %v, want %v", got, Early)
+	}
+	if r, ok := m.Sent("bb"); !ok || r.Cursor != "next" {
+		t.Errorf("request with an early response: got %+v, %v", r, ok)
+	}
+	if got := m.Match(Response{RequestHash: "bb", ReceivedAt: at}); got != Duplicate {
+		t.Errorf("response after an early one: got %v, want %v", got, Duplicate)
+	}
+
+	m.Sent("cc")
+	m.Finish("cc")
+	if got := m.Match(Response{RequestHash: "cc", ReceivedAt: at}); got != Duplicate {
+		t.Errorf("response of a timed out request: got %v, want %v", got, Duplicate)
+	}
+
+	m.Match(Response{RequestHash: "dd", ReceivedAt: at})
+	m.Match(Response{RequestHash: "ee", ReceivedAt: at.Add(time.Minute)})
+	if expired := m.ExpireEarly(at.Add(time.Second)); len(expired) != 1 || expired[0].RequestHash != "dd" {
+		t.Errorf("expired early responses: got %+v, want dd", expired)
+	}
+	if m.Early() != 1 {
+		t.Errorf("early responses: got %d, want 1", m.Early())
+	}
+}
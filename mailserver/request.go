@@ -0,0 +1,48 @@
+// Package mailserver sends history requests to Status Mail Servers and
+// matches the signals which report their results with the requests.
+//
+// A request is sent through the shhext API, which returns its hash.
+// The Mail Server sends the requested envelopes directly and then
+// a response, which status-go reports as a signal with the request hash.
+package mailserver
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/status-im/status-go/services/shhext"
+	whisper "github.com/status-im/whisper/whisperv6"
+)
+
+// RequestTimeout is the time in seconds after which status-go reports
+// a request without a response as expired.
+const RequestTimeout = 30
+
+// Request describes requested envelopes.
+type Request struct {
+	MailServer string // enode
+	SymKeyID   string // of the Mail Server password
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Cursor     string
+	Topics     []whisper.TopicType
+}
+
+// Send sends the request and returns its hex encoded hash.
+func Send(api *shhext.PublicAPI, r Request) (string, error) {
+	hash, err := api.RequestMessages(nil, shhext.MessagesRequest{
+		MailServerPeer: r.MailServer,
+		SymKeyID:       r.SymKeyID,
+		From:           uint32(r.From.Unix()),
+		To:             uint32(r.To.Unix()),
+		Limit:          uint32(r.Limit),
+		Cursor:         r.Cursor,
+		Topics:         r.Topics,
+		Timeout:        RequestTimeout,
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
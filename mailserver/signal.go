@@ -0,0 +1,88 @@
+package mailserver
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/status-im/status-go/signal"
+)
+
+// Outcomes of requests reported by signals.
+const (
+	OutcomeCompleted = "completed" // completion signal without an error
+	OutcomeFailed    = "failed"    // completion signal with an error
+	OutcomeExpired   = "expired"   // expiration signal
+)
+
+// Response is the result of a request reported by a signal.
+type Response struct {
+	RequestHash      string // hex encoded
+	Outcome          string
+	Cursor           string // of the next page, if there is one
+	LastEnvelopeHash string // hex encoded
+	Error            string
+	ReceivedAt       time.Time
+}
+
+// SignalHandler returns a status-go signal handler which passes every signal
+// to next, and responses of Mail Server requests to fn. Signals are handled
+// one by one, so fn must not block for long.
+func SignalHandler(next func(string), fn func(Response)) func(string) {
+	return func(event string) {
+		receivedAt := time.Now()
+		if next != nil {
+			next(event)
+		}
+
+		var envelope signal.Envelope
+		if err := json.Unmarshal([]byte(event), &envelope); err != nil {
+			log.Printf("failed to unmarshal a signal: %v", err)
+			return
+		}
+		if r, ok := ParseSignal(&envelope, receivedAt); ok {
+			fn(r)
+		}
+	}
+}
+
+// ParseSignal returns the response reported by a completed
+// or expired request signal.
+func ParseSignal(e *signal.Envelope, receivedAt time.Time) (Response, bool) {
+	event, ok := e.Event.(map[string]interface{})
+	if !ok {
+		return Response{}, false
+	}
+
+	r := Response{ReceivedAt: receivedAt}
+	switch e.Type {
+	case signal.EventMailServerRequestCompleted:
+		hash, _ := event["requestID"].(string)
+		r.RequestHash = NormalizeHash(hash)
+		r.Outcome = OutcomeCompleted
+		r.Cursor, _ = event["cursor"].(string)
+		if hash, ok := event["lastEnvelopeHash"].(string); ok {
+			r.LastEnvelopeHash = NormalizeHash(hash)
+		}
+		r.Error, _ = event["errorMessage"].(string)
+		if r.Error != "" {
+			r.Outcome = OutcomeFailed
+		}
+	case signal.EventMailServerRequestExpired:
+		hash, _ := event["hash"].(string)
+		r.RequestHash = NormalizeHash(hash)
+		r.Outcome = OutcomeExpired
+	default:
+		return Response{}, false
+	}
+	if r.RequestHash == "" {
+		return Response{}, false
+	}
+	return r, true
+}
+
+// NormalizeHash returns a lower case hex encoded hash without a prefix.
+func NormalizeHash(hash string) string {
+	return strings.TrimPrefix(strings.ToLower(hash), "0x")
+}
@@ -0,0 +1,65 @@
+package mailserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/status-im/status-go/signal"
+)
+
+func TestParseSignal(t *testing.T) {
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name  string
+		event signal.Envelope
+		want  Response
+		ok    bool
+	}{
+		{
+			name: "completed",
+			event: signal.Envelope{Type: signal.EventMailServerRequestCompleted, Event: map[string]interface{}{
+				"requestID":        "0xAB",
+				"cursor":           "0102",
+				"lastEnvelopeHash": "0xCD",
+			}},
+			want: Response{RequestHash: "ab", Outcome: OutcomeCompleted, Cursor: "0102", LastEnvelopeHash: "cd", ReceivedAt: at},
+			ok:   true,
+		},
+		{
+			name: "failed",
+			event: signal.Envelope{Type: signal.EventMailServerRequestCompleted, Event: map[string]interface{}{
+				"requestID":    "0xab",
+				"errorMessage": "invalid cursor",
+			}},
+			want: Response{RequestHash: "ab", Outcome: OutcomeFailed, Error: "invalid cursor", ReceivedAt: at},
+			ok:   true,
+		},
+		{
+			name: "expired",
+			event: signal.Envelope{Type: signal.EventMailServerRequestExpired, Event: map[string]interface{}{
+				"hash": "0xab",
+			}},
+			want: Response{RequestHash: "ab", Outcome: OutcomeExpired, ReceivedAt: at},
+			ok:   true,
+		},
+		{
+			name: "without a hash",
+			event: signal.Envelope{Type: signal.EventMailServerRequestExpired, Event: map[string]interface{}{
+				"requestID": "0xab",
+			}},
+		},
+		{
+			name:  "other signal",
+			event: signal.Envelope{Type: "node.ready", Event: map[string]interface{}{"requestID": "0xab"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := ParseSignal(&tc.event, at)
+			if ok != tc.ok || !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, %v, want %+v, %v", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
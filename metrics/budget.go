@@ -0,0 +1,312 @@
+package metrics
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// OverflowValue replaces values of guarded labels in series over the budget.
+// It is not a valid name of a Status public chat, which consists of
+// lowercase letters, digits and dashes.
+const OverflowValue = "__other__"
+
+// DefaultMaxSeries is the series budget of metric vectors which do not set their own.
+// It may be changed before metrics are collected, for example from a flag.
+var DefaultMaxSeries = 1000
+
+// droppedSketchBits is the size of the sketch counting distinct dropped label
+// sets of a metric. 8 KiB estimate up to a few hundred thousand of them.
+const droppedSketchBits = 1 << 16
+
+var droppedSeriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "shh",
+	Name:      "metrics_dropped_series_total",
+	Help:      "Estimated number of label sets which exceeded the budget of a metric and were folded into the overflow series.",
+}, []string{"metric"})
+
+func init() {
+	prometheus.MustRegister(droppedSeriesCounter)
+}
+
+// BudgetOpts configures a label budget of a metric vector.
+type BudgetOpts struct {
+	// MaxSeries limits the number of series. If zero, DefaultMaxSeries is used.
+	MaxSeries int
+	// Guarded labels have unbounded values, like channel names, and are set
+	// to OverflowValue in series over the budget. Other labels are kept.
+	Guarded []string
+}
+
+// budget admits series of a metric vector until the limit is reached.
+// Admitted series are never evicted, so a label set is always mapped
+// to the same series, which keeps gauges consistent. Dropped label sets
+// are counted in a sketch of a fixed size, so they can't take memory
+// the budget is meant to save.
+type budget struct {
+	metric    string
+	maxSeries int
+	guarded   []bool // by label index
+
+	mu      sync.Mutex
+	series  map[string]struct{}
+	dropped *distinctCounter
+}
+
+func newBudget(metric string, labels []string, opts BudgetOpts) *budget {
+	b := &budget{
+		metric:    metric,
+		maxSeries: opts.MaxSeries,
+		guarded:   make([]bool, len(labels)),
+		series:    make(map[string]struct{}),
+		dropped:   newDistinctCounter(droppedSketchBits),
+	}
+	for i, l := range labels {
+		for _, g := range opts.Guarded {
+			if l == g {
+				b.guarded[i] = true
+			}
+		}
+	}
+	return b
+}
+
+// labels returns label values of the series a sample is recorded in.
+func (b *budget) labels(values []string) []string {
+	if b.admit(values) {
+		return values
+	}
+
+	overflow := make([]string, len(values))
+	for i, v := range values {
+		if i < len(b.guarded) && b.guarded[i] {
+			v = OverflowValue
+		}
+		overflow[i] = v
+	}
+	return overflow
+}
+
+// admit returns true if the label values have or get their own series.
+// Otherwise they are counted as dropped.
+func (b *budget) admit(values []string) bool {
+	key := strings.Join(values, "\xff")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.series[key]; ok {
+		return true
+	}
+
+	max := b.maxSeries
+	if max == 0 {
+		max = DefaultMaxSeries
+	}
+	if len(b.series) < max {
+		b.series[key] = struct{}{}
+		return true
+	}
+
+	if b.dropped.Count() == 0 {
+		log.Printf("metric %s reached its budget of %d series, new series are counted as '%s'", b.metric, max, OverflowValue)
+	}
+	if n := b.dropped.Add(hashString(key)); n > 0 {
+		droppedSeriesCounter.WithLabelValues(b.metric).Add(float64(n))
+	}
+	return false
+}
+
+// admitted returns true if the label values have their own series.
+// Unlike labels, it never admits new series.
+func (b *budget) admitted(values []string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.series[strings.Join(values, "\xff")]
+	return ok
+}
+
+// release forgets the label values if they have their own series,
+// which makes room for another series, and returns true if they had.
+func (b *budget) release(values []string) bool {
+	key := strings.Join(values, "\xff")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.series[key]; !ok {
+		return false
+	}
+	delete(b.series, key)
+	return true
+}
+
+// distinctCounter estimates the number of distinct hashes with linear counting:
+// a hash sets a bit in a bitmap, and the count is derived from the share
+// of bits which are still zero.
+type distinctCounter struct {
+	bits  []uint64
+	set   int
+	count int
+}
+
+func newDistinctCounter(size int) *distinctCounter {
+	return &distinctCounter{bits: make([]uint64, size/64)}
+}
+
+// Add adds the hash and returns how much the estimate grew.
+func (c *distinctCounter) Add(hash uint64) int {
+	m := len(c.bits) * 64
+	i := hash % uint64(m)
+	if c.bits[i/64]&(1<<(i%64)) != 0 || c.set == m-1 {
+		// The bitmap is saturated below the last bit,
+		// where the estimate goes to infinity.
+		return 0
+	}
+	c.bits[i/64] |= 1 << (i % 64)
+	c.set++
+
+	estimate := int(math.Round(-float64(m) * math.Log(1-float64(c.set)/float64(m))))
+	if estimate <= c.count {
+		return 0
+	}
+	n := estimate - c.count
+	c.count = estimate
+	return n
+}
+
+// Count returns the estimated number of distinct hashes.
+func (c *distinctCounter) Count() int {
+	return c.count
+}
+
+// hashString returns an FNV hash of the string with its bits mixed,
+// as low bits of FNV hashes of similar strings are not spread evenly.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return x
+}
+
+// CounterVec is a prometheus.CounterVec with a series budget.
+// Only methods which respect the budget are exposed.
+type CounterVec struct {
+	vec    *prometheus.CounterVec
+	budget *budget
+}
+
+// NewCounterVec creates a counter vector with a series budget.
+func NewCounterVec(opts prometheus.CounterOpts, labels []string, budget BudgetOpts) *CounterVec {
+	return &CounterVec{
+		vec:    prometheus.NewCounterVec(opts, labels),
+		budget: newBudget(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), labels, budget),
+	}
+}
+
+// WithLabelValues returns the counter for the label values,
+// or the overflow counter if the budget is exceeded.
+func (v *CounterVec) WithLabelValues(lvs ...string) prometheus.Counter {
+	return v.vec.WithLabelValues(v.budget.labels(lvs)...)
+}
+
+// Value returns the value of the counter for the label values without
+// creating its series. It is zero for label sets without their own series.
+func (v *CounterVec) Value(lvs ...string) float64 {
+	if !v.budget.admitted(lvs) {
+		return 0
+	}
+	var m dto.Metric
+	if err := v.vec.WithLabelValues(lvs...).Write(&m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+// Describe implements prometheus.Collector.
+func (v *CounterVec) Describe(ch chan<- *prometheus.Desc) { v.vec.Describe(ch) }
+
+// Collect implements prometheus.Collector.
+func (v *CounterVec) Collect(ch chan<- prometheus.Metric) { v.vec.Collect(ch) }
+
+// GaugeVec is a prometheus.GaugeVec with a series budget.
+// Only methods which respect the budget are exposed.
+type GaugeVec struct {
+	vec    *prometheus.GaugeVec
+	budget *budget
+}
+
+// NewGaugeVec creates a gauge vector with a series budget.
+func NewGaugeVec(opts prometheus.GaugeOpts, labels []string, budget BudgetOpts) *GaugeVec {
+	return &GaugeVec{
+		vec:    prometheus.NewGaugeVec(opts, labels),
+		budget: newBudget(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), labels, budget),
+	}
+}
+
+// WithLabelValues returns the gauge for the label values,
+// or the overflow gauge if the budget is exceeded.
+func (v *GaugeVec) WithLabelValues(lvs ...string) prometheus.Gauge {
+	return v.vec.WithLabelValues(v.budget.labels(lvs)...)
+}
+
+// Set sets the gauge for the label values and returns true if they have
+// or get their own series. Otherwise nothing is set, as the last value of
+// any label set folded into the overflow gauge would be meaningless.
+func (v *GaugeVec) Set(value float64, lvs ...string) bool {
+	if !v.budget.admit(lvs) {
+		return false
+	}
+	v.vec.WithLabelValues(lvs...).Set(value)
+	return true
+}
+
+// DeleteLabelValues deletes the gauge for the label values and returns true
+// if it had its own series. The overflow gauge shared with other label sets
+// is never deleted.
+func (v *GaugeVec) DeleteLabelValues(lvs ...string) bool {
+	if !v.budget.release(lvs) {
+		return false
+	}
+	return v.vec.DeleteLabelValues(lvs...)
+}
+
+// Describe implements prometheus.Collector.
+func (v *GaugeVec) Describe(ch chan<- *prometheus.Desc) { v.vec.Describe(ch) }
+
+// Collect implements prometheus.Collector.
+func (v *GaugeVec) Collect(ch chan<- prometheus.Metric) { v.vec.Collect(ch) }
+
+// HistogramVec is a prometheus.HistogramVec with a series budget.
+// Only methods which respect the budget are exposed.
+type HistogramVec struct {
+	vec    *prometheus.HistogramVec
+	budget *budget
+}
+
+// NewHistogramVec creates a histogram vector with a series budget.
+func NewHistogramVec(opts prometheus.HistogramOpts, labels []string, budget BudgetOpts) *HistogramVec {
+	return &HistogramVec{
+		vec:    prometheus.NewHistogramVec(opts, labels),
+		budget: newBudget(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), labels, budget),
+	}
+}
+
+// WithLabelValues returns the histogram for the label values,
+// or the overflow histogram if the budget is exceeded.
+func (v *HistogramVec) WithLabelValues(lvs ...string) prometheus.Observer {
+	return v.vec.WithLabelValues(v.budget.labels(lvs)...)
+}
+
+// Describe implements prometheus.Collector.
+func (v *HistogramVec) Describe(ch chan<- *prometheus.Desc) { v.vec.Describe(ch) }
+
+// Collect implements prometheus.Collector.
+func (v *HistogramVec) Collect(ch chan<- prometheus.Metric) { v.vec.Collect(ch) }
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBudgetLabels(t *testing.T) {
+	labels := []string{"fleet", "chat"}
+
+	for _, tc := range []struct {
+		name    string
+		max     int
+		samples [][]string
+		want    [][]string
+		dropped float64
+	}{
+		{
+			name:    "within budget",
+			max:     2,
+			samples: [][]string{{"prod", "status"}, {"prod", "dev"}, {"prod", "status"}},
+			want:    [][]string{{"prod", "status"}, {"prod", "dev"}, {"prod", "status"}},
+		},
+		{
+			name:    "over budget",
+			max:     1,
+			samples: [][]string{{"prod", "status"}, {"prod", "dev"}, {"beta", "test"}},
+			want:    [][]string{{"prod", "status"}, {"prod", OverflowValue}, {"beta", OverflowValue}},
+			dropped: 2,
+		},
+		{
+			name:    "admitted series stay after the budget is reached",
+			max:     1,
+			samples: [][]string{{"prod", "status"}, {"prod", "dev"}, {"prod", "status"}},
+			want:    [][]string{{"prod", "status"}, {"prod", OverflowValue}, {"prod", "status"}},
+			dropped: 1,
+		},
+		{
+			name:    "dropped label sets are counted once",
+			max:     1,
+			samples: [][]string{{"prod", "status"}, {"prod", "dev"}, {"prod", "dev"}, {"prod", "dev"}},
+			want:    [][]string{{"prod", "status"}, {"prod", OverflowValue}, {"prod", OverflowValue}, {"prod", OverflowValue}},
+			dropped: 1,
+		},
+		{
+			name:    "channel named like the overflow in the old format",
+			max:     1,
+			samples: [][]string{{"prod", "status"}, {"prod", "other"}},
+			want:    [][]string{{"prod", "status"}, {"prod", OverflowValue}},
+			dropped: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			metric := "test_" + strings.Replace(tc.name, " ", "_", -1)
+			b := newBudget(metric, labels, BudgetOpts{MaxSeries: tc.max, Guarded: []string{"chat"}})
+
+			for i, s := range tc.samples {
+				if got := b.labels(s); !reflect.DeepEqual(got, tc.want[i]) {
+					t.Errorf("sample %d: labels(%v) = %v, want %v", i, s, got, tc.want[i])
+				}
+			}
+			if got := testutil.ToFloat64(droppedSeriesCounter.WithLabelValues(metric)); got != tc.dropped {
+				t.Errorf("dropped series = %v, want %v", got, tc.dropped)
+			}
+		})
+	}
+}
+
+func TestBudgetRelease(t *testing.T) {
+	b := newBudget("test_release", []string{"chat"}, BudgetOpts{MaxSeries: 1, Guarded: []string{"chat"}})
+
+	for _, tc := range []struct {
+		op     string // "labels" or "release"
+		chat   string
+		want   string // label value for "labels"
+		wantOK bool   // result for "release"
+	}{
+		{op: "labels", chat: "status", want: "status"},
+		{op: "labels", chat: "dev", want: OverflowValue},
+		{op: "release", chat: "dev", wantOK: false},
+		{op: "release", chat: "status", wantOK: true},
+		{op: "release", chat: "status", wantOK: false},
+		{op: "labels", chat: "dev", want: "dev"},
+		{op: "labels", chat: "status", want: OverflowValue},
+	} {
+		switch tc.op {
+		case "labels":
+			if got := b.labels([]string{tc.chat})[0]; got != tc.want {
+				t.Errorf("labels(%s) = %s, want %s", tc.chat, got, tc.want)
+			}
+		case "release":
+			if got := b.release([]string{tc.chat}); got != tc.wantOK {
+				t.Errorf("release(%s) = %v, want %v", tc.chat, got, tc.wantOK)
+			}
+		}
+	}
+}
+
+func TestCounterVecValue(t *testing.T) {
+	v := NewCounterVec(prometheus.CounterOpts{Name: "test_value"}, []string{"chat"},
+		BudgetOpts{MaxSeries: 2, Guarded: []string{"chat"}})
+	v.WithLabelValues("status").Add(2)
+
+	for _, tc := range []struct {
+		chat string
+		inc  bool // before reading
+		want float64
+	}{
+		{chat: "status", want: 2},
+		{chat: "unknown", want: 0},
+		{chat: "dev", want: 0},
+		// The budget has room for it as reading values doesn't admit series.
+		{chat: "dev", inc: true, want: 1},
+		{chat: "test", inc: true, want: 0},
+	} {
+		if tc.inc {
+			v.WithLabelValues(tc.chat).Inc()
+		}
+		if got := v.Value(tc.chat); got != tc.want {
+			t.Errorf("Value(%s) = %v, want %v", tc.chat, got, tc.want)
+		}
+	}
+}
+
+func TestGaugeVecSet(t *testing.T) {
+	v := NewGaugeVec(prometheus.GaugeOpts{Name: "test_set"}, []string{"chat", "author"},
+		BudgetOpts{MaxSeries: 1, Guarded: []string{"chat", "author"}})
+
+	for _, tc := range []struct {
+		author string
+		value  float64
+		want   bool
+	}{
+		{author: "alice", value: 1, want: true},
+		{author: "bob", value: 2, want: false},
+		{author: "alice", value: 3, want: true},
+	} {
+		if got := v.Set(tc.value, "status", tc.author); got != tc.want {
+			t.Errorf("Set(%v, %s) = %v, want %v", tc.value, tc.author, got, tc.want)
+		}
+	}
+
+	if got := testutil.ToFloat64(v.vec.WithLabelValues("status", "alice")); got != 3 {
+		t.Errorf("gauge of an admitted author = %v, want 3", got)
+	}
+	ch := make(chan prometheus.Metric, 2)
+	v.Collect(ch)
+	if got := len(ch); got != 1 {
+		t.Errorf("series = %d, want 1 without an overflow series", got)
+	}
+}
+
+func TestDistinctCounter(t *testing.T) {
+	for _, tc := range []struct {
+		distinct  int
+		tolerance float64 // relative
+	}{
+		{0, 0},
+		{1, 0},
+		{10, 0},
+		{1000, 0.02},
+		{100000, 0.02},
+	} {
+		t.Run(fmt.Sprint(tc.distinct), func(t *testing.T) {
+			c := newDistinctCounter(droppedSketchBits)
+			total := 0
+			for i := 0; i < tc.distinct; i++ {
+				for repeat := 0; repeat < 2; repeat++ {
+					total += c.Add(hashString(fmt.Sprintf("series-%d", i)))
+				}
+			}
+			if total != c.Count() {
+				t.Errorf("increments add up to %d, but the count is %d", total, c.Count())
+			}
+			diff := float64(c.Count() - tc.distinct)
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > tc.tolerance*float64(tc.distinct) {
+				t.Errorf("count = %d, want %d within %.0f%%", c.Count(), tc.distinct, tc.tolerance*100)
+			}
+		})
+	}
+}
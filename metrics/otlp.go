@@ -0,0 +1,261 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Cumulative aggregation temporality as defined by the OTLP protocol.
+const otlpCumulative = 2
+
+// OTLPSink pushes metrics to an OpenTelemetry collector
+// using OTLP over HTTP with JSON encoding.
+type OTLPSink struct {
+	*pusher
+
+	url      string
+	job      string
+	gatherer prometheus.Gatherer
+	client   *http.Client
+
+	// Prometheus counters are cumulative since the process started.
+	started time.Time
+}
+
+// NewOTLPSink creates a sink pushing metrics to url every interval.
+// The job is sent as the service.name resource attribute.
+func NewOTLPSink(url, job string, g prometheus.Gatherer, interval time.Duration) *OTLPSink {
+	s := &OTLPSink{
+		url:      url,
+		job:      job,
+		gatherer: g,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		started:  time.Now(),
+	}
+	s.pusher = newPusher(url, interval, s.send)
+	return s
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value otlpAttrString `json:"value"`
+}
+
+type otlpAttrString struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpSum struct {
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryPoint `json:"dataPoints"`
+}
+
+// 64-bit integers are encoded as strings in OTLP JSON.
+type otlpNumberPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpSummaryPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	QuantileValues    []otlpQuantile  `json:"quantileValues"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+func (s *OTLPSink) send() error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, f := range families {
+		if m, ok := s.metric(f, now); ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	body, err := json.Marshal(otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{Key: "service.name", Value: otlpAttrString{s.job}}},
+			},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "statusd-bots"},
+				Metrics: metrics,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *OTLPSink) metric(f *dto.MetricFamily, now time.Time) (otlpMetric, bool) {
+	start, ts := unixNano(s.started), unixNano(now)
+	m := otlpMetric{Name: f.GetName(), Description: f.GetHelp()}
+
+	switch f.GetType() {
+	case dto.MetricType_COUNTER:
+		m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		for _, metric := range f.GetMetric() {
+			m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{
+				Attributes:        otlpAttributes(metric.GetLabel()),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				AsDouble:          metric.GetCounter().GetValue(),
+			})
+		}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		m.Gauge = &otlpGauge{}
+		for _, metric := range f.GetMetric() {
+			value := metric.GetGauge().GetValue()
+			if f.GetType() == dto.MetricType_UNTYPED {
+				value = metric.GetUntyped().GetValue()
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{
+				Attributes:   otlpAttributes(metric.GetLabel()),
+				TimeUnixNano: ts,
+				AsDouble:     value,
+			})
+		}
+	case dto.MetricType_HISTOGRAM:
+		m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+		for _, metric := range f.GetMetric() {
+			h := metric.GetHistogram()
+			p := otlpHistogramPoint{
+				Attributes:        otlpAttributes(metric.GetLabel()),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+				Sum:               h.GetSampleSum(),
+			}
+			// Prometheus buckets are cumulative, OTLP ones are not
+			// and have an extra bucket for values above the last bound.
+			var previous uint64
+			for _, b := range h.GetBucket() {
+				p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+				p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-previous, 10))
+				previous = b.GetCumulativeCount()
+			}
+			p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+			m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+		}
+	case dto.MetricType_SUMMARY:
+		m.Summary = &otlpSummary{}
+		for _, metric := range f.GetMetric() {
+			sum := metric.GetSummary()
+			p := otlpSummaryPoint{
+				Attributes:        otlpAttributes(metric.GetLabel()),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				Count:             strconv.FormatUint(sum.GetSampleCount(), 10),
+				Sum:               sum.GetSampleSum(),
+			}
+			for _, q := range sum.GetQuantile() {
+				p.QuantileValues = append(p.QuantileValues, otlpQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+			}
+			m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+		}
+	default:
+		return m, false
+	}
+
+	return m, true
+}
+
+func otlpAttributes(labels []*dto.LabelPair) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(labels))
+	for _, l := range labels {
+		attrs = append(attrs, otlpAttribute{Key: l.GetName(), Value: otlpAttrString{l.GetValue()}})
+	}
+	return attrs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
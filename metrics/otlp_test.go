@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestOTLPHistogram(t *testing.T) {
+	started := time.Unix(100, 0)
+	now := time.Unix(200, 0)
+	s := &OTLPSink{started: started}
+
+	bucket := func(bound float64, count uint64) *dto.Bucket {
+		return &dto.Bucket{UpperBound: proto.Float64(bound), CumulativeCount: proto.Uint64(count)}
+	}
+	family := &dto.MetricFamily{
+		Name: proto.String("latency_seconds"),
+		Help: proto.String("Request latency."),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{{Name: proto.String("fleet"), Value: proto.String("prod")}},
+			Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(10),
+				SampleSum:   proto.Float64(12.5),
+				Bucket:      []*dto.Bucket{bucket(0.5, 2), bucket(1, 2), bucket(5, 7)},
+			},
+		}},
+	}
+
+	m, ok := s.metric(family, now)
+	if !ok {
+		t.Fatal("histogram is not converted")
+	}
+	want := otlpMetric{
+		Name:        "latency_seconds",
+		Description: "Request latency.",
+		Histogram: &otlpHistogram{
+			AggregationTemporality: otlpCumulative,
+			DataPoints: []otlpHistogramPoint{{
+				Attributes:        []otlpAttribute{{Key: "fleet", Value: otlpAttrString{"prod"}}},
+				StartTimeUnixNano: "100000000000",
+				TimeUnixNano:      "200000000000",
+				Count:             "10",
+				Sum:               12.5,
+				// Per bucket counts, with the values above the last bound at the end.
+				BucketCounts:   []string{"2", "0", "5", "3"},
+				ExplicitBounds: []float64{0.5, 1, 5},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("metric = %+v, want %+v", m.Histogram, want.Histogram)
+	}
+}
+
+func TestOTLPHistogramWithoutBuckets(t *testing.T) {
+	s := &OTLPSink{started: time.Unix(100, 0)}
+	family := &dto.MetricFamily{
+		Name:   proto.String("latency_seconds"),
+		Type:   dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{Histogram: &dto.Histogram{SampleCount: proto.Uint64(4), SampleSum: proto.Float64(2)}}},
+	}
+
+	m, _ := s.metric(family, time.Unix(200, 0))
+	p := m.Histogram.DataPoints[0]
+	if want := []string{"4"}; !reflect.DeepEqual(p.BucketCounts, want) {
+		t.Errorf("bucket counts = %v, want %v", p.BucketCounts, want)
+	}
+	if len(p.ExplicitBounds) != 0 {
+		t.Errorf("explicit bounds = %v, want none", p.ExplicitBounds)
+	}
+}
+
+func TestOTLPCounter(t *testing.T) {
+	s := &OTLPSink{started: time.Unix(100, 0)}
+	family := &dto.MetricFamily{
+		Name:   proto.String("requests_total"),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(3)}}},
+	}
+
+	m, ok := s.metric(family, time.Unix(200, 0))
+	if !ok || m.Sum == nil {
+		t.Fatalf("counter is not converted to a sum: %+v", m)
+	}
+	if !m.Sum.IsMonotonic || m.Sum.AggregationTemporality != otlpCumulative {
+		t.Errorf("sum = %+v, want a monotonic cumulative sum", m.Sum)
+	}
+	if p := m.Sum.DataPoints[0]; p.AsDouble != 3 || p.StartTimeUnixNano != "100000000000" {
+		t.Errorf("data point = %+v", p)
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// PrometheusSink serves metrics to be scraped by Prometheus.
+type PrometheusSink struct {
+	server *http.Server
+}
+
+// NewPrometheusSink creates a sink serving metrics on addr under /metrics.
+func NewPrometheusSink(addr string, g prometheus.Gatherer) *PrometheusSink {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	return &PrometheusSink{
+		server: &http.Server{Addr: addr, Handler: mux},
+	}
+}
+
+// Start starts the HTTP server. A failure to listen is fatal,
+// as the metrics would be silently unavailable otherwise.
+func (s *PrometheusSink) Start() error {
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	return nil
+}
+
+// Close stops the HTTP server.
+func (s *PrometheusSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// PushgatewaySink pushes metrics to a Prometheus Pushgateway.
+type PushgatewaySink struct {
+	*pusher
+}
+
+// NewPushgatewaySink creates a sink pushing metrics to the Pushgateway at url
+// every interval. The job is used as the grouping key.
+func NewPushgatewaySink(url, job string, g prometheus.Gatherer, interval time.Duration) *PushgatewaySink {
+	p := push.New(url, job).Gatherer(g)
+	return &PushgatewaySink{
+		pusher: newPusher(url, interval, p.Push),
+	}
+}
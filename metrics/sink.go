@@ -0,0 +1,163 @@
+// Package metrics exports metrics collected by the bots to various backends.
+//
+// Metrics are always collected in a Prometheus registry. A Sink takes
+// them from a prometheus.Gatherer and either serves them to be scraped
+// or pushes them periodically, which suits bots running behind NAT
+// or as short jobs.
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultPushInterval is used by push sinks if no interval is specified.
+const DefaultPushInterval = 15 * time.Second
+
+// Sink exports metrics.
+type Sink interface {
+	// Start starts exporting metrics in the background.
+	Start() error
+	// Close exports metrics for the last time, if the sink pushes them,
+	// and stops exporting.
+	Close() error
+}
+
+// ParseSink creates a sink from a URL-like specification:
+//
+//	prometheus://:8080                  serve metrics to be scraped on /metrics
+//	pushgateway://host:9091?interval=15s push to a Prometheus Pushgateway
+//	statsd://host:8125?prefix=bots       send to a StatsD server over UDP
+//	otlp://host:4318                     push to an OpenTelemetry collector over HTTP
+//
+// Schemes of push sinks using HTTP accept a "+https" suffix, for example otlp+https://.
+// Job identifies the bot in pushed metrics.
+func ParseSink(spec, job string, g prometheus.Gatherer) (Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := DefaultPushInterval
+	if v := u.Query().Get("interval"); v != "" {
+		interval, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %v", err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+	}
+
+	scheme, httpScheme := u.Scheme, "http"
+	if strings.HasSuffix(scheme, "+https") {
+		scheme, httpScheme = strings.TrimSuffix(scheme, "+https"), "https"
+	}
+
+	switch scheme {
+	case "prometheus":
+		return NewPrometheusSink(u.Host, g), nil
+	case "pushgateway":
+		return NewPushgatewaySink(httpScheme+"://"+u.Host+u.Path, job, g, interval), nil
+	case "statsd":
+		return NewStatsDSink(u.Host, u.Query().Get("prefix"), g, interval), nil
+	case "otlp":
+		path := u.Path
+		if path == "" {
+			path = "/v1/metrics"
+		}
+		return NewOTLPSink(httpScheme+"://"+u.Host+path, job, g, interval), nil
+	default:
+		return nil, fmt.Errorf("unknown metrics sink '%s'", u.Scheme)
+	}
+}
+
+// Sinks is a group of sinks started and closed together.
+type Sinks []Sink
+
+// StartSinks parses and starts sinks from the specifications.
+func StartSinks(specs []string, job string, g prometheus.Gatherer) (Sinks, error) {
+	var sinks Sinks
+	for _, spec := range specs {
+		s, err := ParseSink(spec, job, g)
+		if err != nil {
+			_ = sinks.Close()
+			return nil, err
+		}
+		if err := s.Start(); err != nil {
+			_ = sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// Close closes all sinks and returns the first error.
+func (s Sinks) Close() error {
+	var firstErr error
+	for _, sink := range s {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// pusher calls push every interval and once more when closed.
+type pusher struct {
+	name     string
+	interval time.Duration
+	push     func() error
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+func newPusher(name string, interval time.Duration, push func() error) *pusher {
+	return &pusher{
+		name:     name,
+		interval: interval,
+		push:     push,
+		quit:     make(chan struct{}),
+	}
+}
+
+func (p *pusher) Start() error {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.push(); err != nil {
+					log.Printf("failed to push metrics to %s: %v", p.name, err)
+				}
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *pusher) Close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.quit)
+		p.wg.Wait()
+		err = p.push()
+	})
+	return err
+}
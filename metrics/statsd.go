@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Maximum size of a UDP packet which is safe to send over the Internet.
+const statsdMaxPacketSize = 1432
+
+// StatsDSink sends metrics to a StatsD server over UDP.
+// Labels are sent as DogStatsD tags, which are understood
+// by Datadog, Telegraf and the StatsD exporter for Prometheus.
+type StatsDSink struct {
+	*pusher
+
+	addr     string
+	prefix   string
+	gatherer prometheus.Gatherer
+
+	// StatsD counters are deltas, so previous values of cumulative
+	// Prometheus counters are kept.
+	last map[string]float64
+}
+
+// NewStatsDSink creates a sink sending metrics to addr every interval.
+// If prefix is not empty, it is prepended to metric names.
+func NewStatsDSink(addr, prefix string, g prometheus.Gatherer, interval time.Duration) *StatsDSink {
+	s := &StatsDSink{
+		addr:     addr,
+		prefix:   prefix,
+		gatherer: g,
+		last:     make(map[string]float64),
+	}
+	s.pusher = newPusher(addr, interval, s.send)
+	return s
+}
+
+func (s *StatsDSink) send() error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var lines []string
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			lines = append(lines, s.lines(f, m)...)
+		}
+	}
+
+	for _, packet := range statsdPackets(lines, statsdMaxPacketSize) {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsdPackets joins lines with newlines into packets of at most max bytes.
+// A line longer than max is sent in a packet of its own.
+func statsdPackets(lines []string, max int) [][]byte {
+	var (
+		packets [][]byte
+		packet  bytes.Buffer
+	)
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > max {
+			packets = append(packets, append([]byte(nil), packet.Bytes()...))
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.Bytes())
+	}
+	return packets
+}
+
+func (s *StatsDSink) lines(f *dto.MetricFamily, m *dto.Metric) []string {
+	name := f.GetName()
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+	tags := statsdTags(m.GetLabel())
+
+	switch f.GetType() {
+	case dto.MetricType_COUNTER:
+		return s.counter(name, tags, m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return []string{statsdLine(name, m.GetGauge().GetValue(), "g", tags)}
+	case dto.MetricType_UNTYPED:
+		return []string{statsdLine(name, m.GetUntyped().GetValue(), "g", tags)}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		return append(
+			s.counter(name+".count", tags, float64(h.GetSampleCount())),
+			s.counter(name+".sum", tags, h.GetSampleSum())...,
+		)
+	case dto.MetricType_SUMMARY:
+		sum := m.GetSummary()
+		return append(
+			s.counter(name+".count", tags, float64(sum.GetSampleCount())),
+			s.counter(name+".sum", tags, sum.GetSampleSum())...,
+		)
+	}
+	return nil
+}
+
+// counter returns a line with an increase of the counter since the last push.
+func (s *StatsDSink) counter(name, tags string, value float64) []string {
+	key := name + tags
+	delta := value - s.last[key]
+	s.last[key] = value
+	if delta <= 0 {
+		return nil
+	}
+	return []string{statsdLine(name, delta, "c", tags)}
+}
+
+func statsdLine(name string, value float64, typ, tags string) string {
+	line := fmt.Sprintf("%s:%s|%s", name, strconv.FormatFloat(value, 'f', -1, 64), typ)
+	if tags != "" {
+		line += "|#" + tags
+	}
+	return line
+}
+
+func statsdTags(labels []*dto.LabelPair) string {
+	tags := make([]string, 0, len(labels))
+	for _, l := range labels {
+		tags = append(tags, l.GetName()+":"+strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(l.GetValue()))
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
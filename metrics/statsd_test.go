@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestStatsDCounterDelta(t *testing.T) {
+	s := NewStatsDSink("localhost:8125", "bot", nil, 0)
+	family := &dto.MetricFamily{Name: proto.String("requests_total"), Type: dto.MetricType_COUNTER.Enum()}
+	labels := []*dto.LabelPair{
+		{Name: proto.String("fleet"), Value: proto.String("prod")},
+		{Name: proto.String("chat"), Value: proto.String("a|b")},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		value float64
+		want  []string
+	}{
+		{"first push sends the whole value", 3, []string{"bot.requests_total:3|c|#chat:a_b,fleet:prod"}},
+		{"increase", 5.5, []string{"bot.requests_total:2.5|c|#chat:a_b,fleet:prod"}},
+		{"no change", 5.5, nil},
+		{"reset is not sent", 1, nil},
+		{"increase after a reset", 2, []string{"bot.requests_total:1|c|#chat:a_b,fleet:prod"}},
+	} {
+		m := &dto.Metric{Label: labels, Counter: &dto.Counter{Value: proto.Float64(tc.value)}}
+		if got := s.lines(family, m); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: lines = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestStatsDHistogram(t *testing.T) {
+	s := NewStatsDSink("localhost:8125", "", nil, 0)
+	family := &dto.MetricFamily{Name: proto.String("latency_seconds"), Type: dto.MetricType_HISTOGRAM.Enum()}
+	metric := func(count uint64, sum float64) *dto.Metric {
+		return &dto.Metric{Histogram: &dto.Histogram{SampleCount: proto.Uint64(count), SampleSum: proto.Float64(sum)}}
+	}
+
+	if got, want := s.lines(family, metric(2, 1.5)), []string{"latency_seconds.count:2|c", "latency_seconds.sum:1.5|c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %v, want %v", got, want)
+	}
+	if got, want := s.lines(family, metric(3, 1.5)), []string{"latency_seconds.count:1|c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %v, want %v", got, want)
+	}
+}
+
+func TestStatsDPackets(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		lines []string
+		max   int
+		want  []string
+	}{
+		{"no lines", nil, 10, nil},
+		{"single packet", []string{"a:1|c", "b:2|c"}, 11, []string{"a:1|c\nb:2|c"}},
+		{"split when the newline does not fit", []string{"a:1|c", "b:2|c"}, 10, []string{"a:1|c", "b:2|c"}},
+		{"long line in its own packet", []string{"a:1|c", strings.Repeat("x", 20), "b:2|c"}, 10,
+			[]string{"a:1|c", strings.Repeat("x", 20), "b:2|c"}},
+		{"several lines per packet", []string{"a:1|c", "b:2|c", "c:3|c", "d:4|c", "e:5|c"}, 17,
+			[]string{"a:1|c\nb:2|c\nc:3|c", "d:4|c\ne:5|c"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, p := range statsdPackets(tc.lines, tc.max) {
+				got = append(got, string(p))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("packets = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}